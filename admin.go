@@ -0,0 +1,131 @@
+package snatch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// AdminOpts configures the admin handler.
+type AdminOpts struct {
+	// Reload reloads the configuration. The reload endpoint is only
+	// served when it is set.
+	Reload func() error
+}
+
+// NewAdminHandler creates an http.Handler exposing the health and
+// state of the Application.
+//
+// The handler serves:
+//
+//	/healthz        the liveness of the process
+//	/readyz         the readiness, failing when the database is unreachable or the queue is full
+//	/flush          (POST) flushes all Buckets into the database
+//	/series         the series held in the Store
+//	/reload         (POST) reloads the configuration
+//	/debug/pprof/   the runtime profiles
+func NewAdminHandler(app *Application, opts AdminOpts) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeText(w, http.StatusOK, "ok")
+	})
+	mux.HandleFunc("/readyz", app.handleReady)
+	mux.HandleFunc("/flush", handlePost(app.Flush))
+	mux.HandleFunc("/series", app.handleSeries)
+	if opts.Reload != nil {
+		mux.HandleFunc("/reload", handlePost(opts.Reload))
+	}
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+func (a *Application) handleReady(w http.ResponseWriter, r *http.Request) {
+	a.rw.RLock()
+	db := a.db
+	a.rw.RUnlock()
+
+	if p, ok := db.(Pinger); ok {
+		if err := p.Ping(); err != nil {
+			writeText(w, http.StatusServiceUnavailable, "database unreachable: "+err.Error())
+			return
+		}
+	}
+
+	if a.Saturated() {
+		writeText(w, http.StatusServiceUnavailable, "queue saturated")
+		return
+	}
+
+	writeText(w, http.StatusOK, "ok")
+}
+
+// handlePost returns a handler running the action on a POST request.
+func handlePost(action func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeText(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if err := action(); err != nil {
+			writeText(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeText(w, http.StatusOK, "ok")
+	}
+}
+
+// seriesInfo is the listed state of a series.
+type seriesInfo struct {
+	Type Type              `json:"type"`
+	Name string            `json:"name"`
+	Tags map[string]string `json:"tags,omitempty"`
+	Time time.Time         `json:"time"`
+	Res  string            `json:"res,omitempty"`
+	Late bool              `json:"late,omitempty"`
+}
+
+func (a *Application) handleSeries(w http.ResponseWriter, r *http.Request) {
+	a.rw.RLock()
+	sl, ok := a.s.(SeriesLister)
+	a.rw.RUnlock()
+	if !ok {
+		writeText(w, http.StatusNotImplemented, "store does not list series")
+		return
+	}
+
+	ids := sl.Series()
+	out := make([]seriesInfo, 0, len(ids))
+	for _, id := range ids {
+		info := seriesInfo{Type: id.Type, Name: id.Name, Time: id.Time, Late: id.Late}
+		if len(id.Tags) > 0 {
+			info.Tags = make(map[string]string, len(id.Tags)/2)
+			for i := 0; i+1 < len(id.Tags); i += 2 {
+				info.Tags[id.Tags[i]] = id.Tags[i+1]
+			}
+		}
+		if id.Res > 0 {
+			info.Res = id.Res.String()
+		}
+
+		out = append(out, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func writeText(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(msg + "\n"))
+}
@@ -0,0 +1,200 @@
+package snatch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockPingDB struct {
+	mockDB
+}
+
+func (m *mockPingDB) Ping() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func serveAdmin(app *snatch.Application, method, path string) *httptest.ResponseRecorder {
+	return serveAdminWith(app, snatch.AdminOpts{}, method, path)
+}
+
+func serveAdminWith(app *snatch.Application, opts snatch.AdminOpts, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, nil)
+	snatch.NewAdminHandler(app, opts).ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAdmin_Healthz(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+
+	w := serveAdmin(app, http.MethodGet, "/healthz")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_Readyz(t *testing.T) {
+	db := new(mockPingDB)
+	db.On("Ping").Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, new(mockStore))
+
+	w := serveAdmin(app, http.MethodGet, "/readyz")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_ReadyzDatabaseUnreachable(t *testing.T) {
+	db := new(mockPingDB)
+	db.On("Ping").Return(errors.New("test"))
+	app := snatch.NewApplication(10*time.Second, db, new(mockStore))
+
+	w := serveAdmin(app, http.MethodGet, "/readyz")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "database unreachable")
+}
+
+func TestAdmin_ReadyzQueueSaturated(t *testing.T) {
+	added := make(chan struct{}, 10)
+	block := make(chan struct{})
+	pr, pw := io.Pipe()
+	defer close(block)
+	defer pw.Close()
+
+	db := new(mockPingDB)
+	db.On("Ping").Return(nil)
+	s := new(mockStore)
+	s.On("Add", mock.Anything).Run(func(mock.Arguments) {
+		added <- struct{}{}
+		<-block
+	}).Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	opts := snatch.ParseOpts{BufferSize: 1, AllowedPending: 1}
+
+	go func() {
+		_ = app.Parse(pr, opts, func([]byte) {})
+	}()
+	// Block the parser, then fill the queue.
+	_, _ = pw.Write([]byte("count#test=1\n"))
+	<-added
+	_, _ = pw.Write([]byte("count#test=1\n"))
+	for i := 0; i < 1000 && !app.Saturated(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := serveAdmin(app, http.MethodGet, "/readyz")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "queue saturated")
+}
+
+func TestAdmin_Flush(t *testing.T) {
+	out := make(chan *snatch.Bucket)
+	close(out)
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	s := new(mockStore)
+	s.On("Flush").Return(out, nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	w := serveAdmin(app, http.MethodPost, "/flush")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.AssertExpectations(t)
+}
+
+func TestAdmin_FlushError(t *testing.T) {
+	out := make(chan *snatch.Bucket)
+	close(out)
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(errors.New("test"))
+	s := new(mockStore)
+	s.On("Flush").Return(out, nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	w := serveAdmin(app, http.MethodPost, "/flush")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestAdmin_FlushRequiresPost(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+
+	w := serveAdmin(app, http.MethodGet, "/flush")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAdmin_Series(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	s := snatch.NewStore(10 * time.Second)
+	_ = s.Add(
+		&snatch.Bucket{ID: &snatch.ID{Type: snatch.Count, Name: "b", Time: now, Tags: []string{"foo", "bar"}}},
+		&snatch.Bucket{ID: &snatch.ID{Type: snatch.Count, Name: "a", Time: now}},
+	)
+	app := snatch.NewApplication(10*time.Second, new(mockDB), s)
+
+	w := serveAdmin(app, http.MethodGet, "/series")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got []map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got)) && assert.Len(t, got, 2) {
+		assert.Equal(t, "a", got[0]["name"])
+		assert.Equal(t, "b", got[1]["name"])
+		assert.Equal(t, map[string]interface{}{"foo": "bar"}, got[1]["tags"])
+	}
+}
+
+func TestAdmin_SeriesUnsupportedStore(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+
+	w := serveAdmin(app, http.MethodGet, "/series")
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestAdmin_Reload(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+	called := false
+	opts := snatch.AdminOpts{Reload: func() error {
+		called = true
+		return nil
+	}}
+
+	w := serveAdminWith(app, opts, http.MethodPost, "/reload")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, called)
+}
+
+func TestAdmin_ReloadError(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+	opts := snatch.AdminOpts{Reload: func() error {
+		return errors.New("invalid config")
+	}}
+
+	w := serveAdminWith(app, opts, http.MethodPost, "/reload")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid config")
+}
+
+func TestAdmin_ReloadNotConfigured(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+
+	w := serveAdmin(app, http.MethodPost, "/reload")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
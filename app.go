@@ -3,100 +3,353 @@ package snatch
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 	"time"
 )
 
 // Application is the application context.
 type Application struct {
+	// rw guards the components and settings replaced by Reload.
+	rw sync.RWMutex
 	db DB
 	p  *Parser
 	s  Store
+
+	clock    Clock
+	res      time.Duration
+	interval time.Duration
+	lateness time.Duration
+
+	// ins serializes taking Buckets from the Store, inserting them and
+	// committing, so a commit never loses Buckets taken by another call.
+	ins sync.Mutex
+
+	stats       *stats
+	selfMetrics bool
+	selfPrefix  string
+
+	mu      sync.Mutex
+	queue   chan *bytes.Buffer
+	bufSize int
 }
 
 // NewApplication creates a new Application.
-func NewApplication(res time.Duration, db DB, s Store) *Application {
-	return &Application{
-		db: db,
-		p:  NewParser(res),
-		s:  s,
+func NewApplication(res time.Duration, db DB, s Store, opts ...Option) *Application {
+	a := &Application{
+		clock: newOptions(opts).clock,
+		stats: newStats(),
+	}
+	a.configure(res, db, s, opts)
+
+	return a
+}
+
+// configure sets the components and settings of the Application.
+func (a *Application) configure(res time.Duration, db DB, s Store, opts []Option) {
+	o := newOptions(opts)
+
+	// Scan at the finest resolution so that overridden metrics are not delayed.
+	interval := res
+	for _, r := range o.rules {
+		if r.Res < interval {
+			interval = r.Res
+		}
 	}
+
+	// The clock cannot be replaced, as the scan loop may be waiting on it.
+	opts = append(opts, WithClock(a.clock))
+
+	a.db = db
+	a.p = NewParser(res, opts...)
+	a.s = s
+	a.res = res
+	a.interval = interval
+	a.lateness = o.lateness
+	a.selfMetrics = o.selfMetrics
+	a.selfPrefix = o.selfPrefix
+}
+
+// RunOpts configures the running of an Application.
+type RunOpts struct {
+	ParseOpts
+
+	// InvalidLine is called with each line that cannot be parsed.
+	InvalidLine func([]byte)
+	// Final writes the remaining Buckets once parsing has stopped.
+	// It defaults to Flush.
+	Final func() error
+}
+
+// Run parses lines from the Reader, periodically inserting complete
+// Buckets into the database, until the Reader is exhausted or the
+// context is canceled. The remaining Buckets are written before Run
+// returns.
+//
+// A canceled context stops reading, but a Read that is blocked will
+// only return once the Reader returns.
+func (a *Application) Run(ctx context.Context, r io.Reader, opts RunOpts) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if opts.InvalidLine == nil {
+		opts.InvalidLine = func([]byte) {}
+	}
+	if opts.Final == nil {
+		opts.Final = a.Flush
+	}
+
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- a.scan(ctx)
+		// Stop parsing if scanning failed.
+		cancel()
+	}()
+
+	opts.Stop = ctx.Done()
+	parseErr := a.Parse(r, opts.ParseOpts, opts.InvalidLine)
+	cancel()
+
+	err := <-scanErr
+	if err == nil {
+		err = parseErr
+	}
+
+	if finalErr := opts.Final(); err == nil {
+		err = finalErr
+	}
+
+	return err
+}
+
+// scan periodically inserts complete Buckets into the database, aligned
+// to the interval boundaries once the lateness has passed, until the
+// context is canceled.
+func (a *Application) scan(ctx context.Context) error {
+	for {
+		now := a.clock.Now()
+		next := a.nextScan(now)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-a.clock.After(next.Sub(now)):
+		}
+
+		if err := a.Scan(); err != nil {
+			return err
+		}
+	}
+}
+
+// nextScan returns the time of the next scan after the given time,
+// aligned to the interval boundaries once the lateness has passed.
+func (a *Application) nextScan(now time.Time) time.Time {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	return now.Truncate(a.interval).Add(a.interval + a.lateness%a.interval)
 }
 
 type ParseOpts struct {
 	BufferSize     int
 	AllowedPending int
+	// Stop stops reading when closed. Lines that were already read,
+	// including those in a partially filled buffer, are parsed.
+	Stop <-chan struct{}
 }
 
 var parserPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
 
 // Parse parses lines from the Reader, adding them to the Store.
+//
+// Queued buffers are always parsed before Parse returns, including
+// when the Reader returns an error or reading is stopped.
 func (a *Application) Parse(r io.Reader, opts ParseOpts, errFn func([]byte)) error {
-	drops := 0
 	wg := sync.WaitGroup{}
 	in := make(chan *bytes.Buffer, opts.AllowedPending)
-	buf := parserPool.Get().(*bytes.Buffer)
+	a.setQueue(in, opts.BufferSize)
+	defer a.setQueue(nil, 0)
 
 	wg.Add(1)
 	go a.parseBuffers(in, &wg, errFn)
 
+	lr := &lineReader{
+		a:       a,
+		in:      in,
+		pending: opts.AllowedPending,
+		done:    make(chan struct{}),
+		buf:     parserPool.Get().(*bytes.Buffer),
+	}
+	go lr.read(r)
+
+	select {
+	case <-lr.done:
+	case <-opts.Stop:
+	}
+
+	buf, err := lr.stop()
+	if buf.Len() > 0 {
+		a.stats.read(countLines(buf.Bytes()), false)
+		in <- buf
+	}
+
+	close(in)
+	wg.Wait()
+
+	return err
+}
+
+// setQueue sets the queue of buffers waiting to be parsed and the
+// size of the buffers.
+func (a *Application) setQueue(q chan *bytes.Buffer, size int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.queue = q
+	a.bufSize = size
+}
+
+// bufferSize returns the size of the buffers being read.
+func (a *Application) bufferSize() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.bufSize
+}
+
+// SetBufferSize changes the size of the buffers being read by Parse.
+func (a *Application) SetBufferSize(size int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.bufSize = size
+}
+
+// Saturated determines if the queue of buffers waiting to be parsed
+// is full, causing read lines to be dropped.
+func (a *Application) Saturated() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.queue != nil && len(a.queue) >= cap(a.queue)
+}
+
+// lineReader reads lines into buffers, queueing each full buffer to be
+// parsed. It can be stopped while blocked reading, handing back the
+// partially filled buffer.
+type lineReader struct {
+	a       *Application
+	in      chan<- *bytes.Buffer
+	pending int
+	done    chan struct{}
+
+	mu      sync.Mutex
+	buf     *bytes.Buffer
+	err     error
+	stopped bool
+	drops   int
+}
+
+// read reads lines from the Reader until it is exhausted or the
+// lineReader is stopped.
+func (lr *lineReader) read(r io.Reader) {
+	defer close(lr.done)
+
 	rd := bufio.NewReader(r)
 	for {
 		b, err := rd.ReadSlice('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-
-			return err
+		if !lr.add(b, err) {
+			return
 		}
+	}
+}
 
-		buf.Write(b)
+// add adds a read line to the buffer, returning false once reading
+// has ended.
+func (lr *lineReader) add(b []byte, err error) bool {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
 
-		if buf.Len() < opts.BufferSize {
-			continue
+	if lr.stopped {
+		return false
+	}
+	if err != nil {
+		if err != io.EOF {
+			lr.err = err
 		}
+		lr.stopped = true
+		return false
+	}
 
-		// Swap buffers
-		select {
-		case in <- buf:
-		default:
-			parserPool.Put(buf)
-			drops++
-			if drops == 1 || opts.AllowedPending == 0 || drops%opts.AllowedPending == 0 {
-				fmt.Printf("snatch: message queue full. Dropped %d messages so far.\n", drops)
-
-			}
-		}
+	lr.buf.Write(b)
 
-		buf = parserPool.Get().(*bytes.Buffer)
+	if lr.buf.Len() < lr.a.bufferSize() {
+		return true
 	}
 
-	if buf.Len() > 0 {
-		in <- buf
+	lines := countLines(lr.buf.Bytes())
+
+	// Swap buffers
+	select {
+	case lr.in <- lr.buf:
+		lr.a.stats.read(lines, false)
+	default:
+		lr.a.stats.read(lines, true)
+		lr.buf.Reset()
+		parserPool.Put(lr.buf)
+		lr.drops++
+		if lr.drops == 1 || lr.pending == 0 || lr.drops%lr.pending == 0 {
+			fmt.Fprintf(os.Stderr, "snatch: message queue full. Dropped %d messages so far.\n", lr.drops)
+		}
 	}
 
-	close(in)
-	wg.Wait()
+	lr.buf = parserPool.Get().(*bytes.Buffer)
+	return true
+}
 
-	return nil
+// stop stops reading, returning the partially filled buffer and the
+// error that ended reading, if any.
+func (lr *lineReader) stop() (*bytes.Buffer, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lr.stopped = true
+	buf := lr.buf
+	lr.buf = nil
+
+	return buf, lr.err
 }
 
 func (a *Application) parseBuffers(in chan *bytes.Buffer, wg *sync.WaitGroup, errFn func([]byte)) {
 	defer wg.Done()
 
 	for buf := range in {
+		// Hold the components for the buffer, so a reload waits for it.
+		a.rw.RLock()
+
+		parsed := int64(0)
+		errs := map[string]int64{}
 		for {
 			b, err := buf.ReadBytes('\n')
 			if len(b) > 0 {
 				bkts, err := a.p.Parse(b)
 				if err != nil || len(bkts) == 0 {
+					reason := ReasonNoMetrics
+					if err != nil {
+						reason = ParseErrorReason(err)
+					}
+					errs[reason]++
+
 					errFn(b)
 					continue
 				}
 
+				parsed++
 				_ = a.s.Add(bkts...)
 			}
 
@@ -104,6 +357,16 @@ func (a *Application) parseBuffers(in chan *bytes.Buffer, wg *sync.WaitGroup, er
 				break
 			}
 		}
+		a.stats.parsed(parsed, errs)
+
+		// Insert Buckets flushed early by the budget right away,
+		// rather than holding them until the next scan.
+		if sr, ok := a.s.(StatsReporter); ok && sr.Stats().Early > 0 {
+			if err := a.scanStore(); err != nil {
+				fmt.Fprintf(os.Stderr, "snatch: could not insert early buckets: %v\n", err)
+			}
+		}
+		a.rw.RUnlock()
 
 		parserPool.Put(buf)
 	}
@@ -111,30 +374,111 @@ func (a *Application) parseBuffers(in chan *bytes.Buffer, wg *sync.WaitGroup, er
 
 // Scan inserts complete Buckets into the database.
 func (a *Application) Scan() error {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	return a.scanStore()
+}
+
+func (a *Application) scanStore() error {
+	a.ins.Lock()
+	defer a.ins.Unlock()
+
 	out, err := a.s.Scan()
 	if err != nil {
 		return err
 	}
 
-	var bkts []*Bucket
-	for bkt := range out {
-		bkts = append(bkts, bkt)
-	}
-
-	return a.db.Insert(bkts)
+	return a.insert(out)
 }
 
 // Flush inserts all Buckets into the database.
 func (a *Application) Flush() error {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	return a.flush()
+}
+
+func (a *Application) flush() error {
+	a.ins.Lock()
+	defer a.ins.Unlock()
+
 	out, err := a.s.Flush()
 	if err != nil {
 		return err
 	}
 
+	return a.insert(out)
+}
+
+// Snapshot inserts complete Buckets into the database and writes
+// the remaining Buckets to the Writer, to be restored on startup.
+//
+// Snapshot is intended to be called on shutdown, in place of Flush.
+func (a *Application) Snapshot(w io.Writer) error {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	ss, ok := a.s.(Snapshotter)
+	if !ok {
+		return errors.New("snatch: store does not support snapshots")
+	}
+
+	if err := a.scanStore(); err != nil {
+		return err
+	}
+
+	return ss.Snapshot(w)
+}
+
+// Restore adds the Buckets from a snapshot into the Store.
+func (a *Application) Restore(r io.Reader) error {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	ss, ok := a.s.(Snapshotter)
+	if !ok {
+		return errors.New("snatch: store does not support snapshots")
+	}
+
+	return ss.Restore(r)
+}
+
+func (a *Application) insert(out <-chan *Bucket) error {
 	var bkts []*Bucket
 	for bkt := range out {
 		bkts = append(bkts, bkt)
 	}
 
-	return a.db.Insert(bkts)
+	series := len(bkts)
+
+	// The Application's own metrics are reported once per interval, so
+	// a second insert in the interval does not overwrite them. Inserts
+	// are serialized by ins, so they are reported once.
+	var cur Stats
+	ts := a.selfTime()
+	prev, reportedAt := a.stats.lastReported()
+	report := a.selfMetrics && !ts.Equal(reportedAt)
+	if report {
+		cur = a.currentStats()
+		bkts = append(bkts, a.selfBuckets(ts, cur, prev)...)
+	}
+
+	start := a.clock.Now()
+	err := a.db.Insert(bkts)
+	a.stats.inserted(series, a.clock.Now().Sub(start), err)
+	if err != nil {
+		return err
+	}
+
+	if report {
+		a.stats.report(cur, ts)
+	}
+
+	if c, ok := a.s.(Committer); ok {
+		return c.Commit()
+	}
+
+	return nil
 }
@@ -2,7 +2,10 @@ package snatch_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -65,6 +68,108 @@ lvl=info msg= count#test=2 foo="bar" size=10
 	s.AssertExpectations(t)
 }
 
+func TestApplication_ParseInsertsEarlyBuckets(t *testing.T) {
+	now := time.Now().Truncate(10 * time.Second)
+	clock := snatch.NewFakeClock(now.Add(2 * time.Second))
+	db := new(mockDB)
+	db.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 1 && bkts[0].ID.Name == "old"
+	})).Return(nil).Once()
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock), snatch.WithBudget(snatch.Budget{
+		MaxSeries: 1,
+		Policy:    snatch.BudgetFlush,
+	}))
+	_ = s.Add(&snatch.Bucket{ID: &snatch.ID{Time: now.Add(-10 * time.Second), Name: "old", Type: snatch.Count}, Vals: []float64{1}, Sum: 1})
+	app := snatch.NewApplication(10*time.Second, db, s, snatch.WithClock(clock))
+	opts := snatch.ParseOpts{BufferSize: 1, AllowedPending: 1}
+
+	err := app.Parse(bytes.NewReader([]byte("count#test=1\n")), opts, func([]byte) {})
+
+	assert.NoError(t, err)
+	db.AssertExpectations(t)
+	assert.Equal(t, 1, s.(snatch.StatsReporter).Stats().Series)
+}
+
+func TestApplication_ParseStops(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	added := make(chan struct{}, 10)
+	db := new(mockDB)
+	s := new(mockStore)
+	s.On("Add", mock.Anything).Run(func(mock.Arguments) {
+		added <- struct{}{}
+	}).Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	stop := make(chan struct{})
+	opts := snatch.ParseOpts{BufferSize: 10, AllowedPending: 2, Stop: stop}
+
+	done := make(chan error)
+	go func() {
+		done <- app.Parse(r, opts, func(b []byte) {})
+	}()
+	_, _ = w.Write([]byte("lvl=info msg= count#test=2 foo=\"bar\" size=10\n"))
+	<-added
+	close(stop)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Parse did not stop")
+	}
+}
+
+func TestApplication_ParseStopParsesPartialBuffer(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	added := make(chan struct{}, 10)
+	db := new(mockDB)
+	s := new(mockStore)
+	s.On("Add", mock.Anything).Run(func(mock.Arguments) {
+		added <- struct{}{}
+	}).Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	stop := make(chan struct{})
+	opts := snatch.ParseOpts{BufferSize: 1000, AllowedPending: 2, Stop: stop}
+
+	done := make(chan error)
+	go func() {
+		done <- app.Parse(r, opts, func(b []byte) {})
+	}()
+	// The second write returns once the first line has been buffered.
+	_, _ = w.Write([]byte("lvl=info msg= count#test=2 foo=\"bar\" size=10\n"))
+	_, _ = w.Write([]byte("lvl=info msg= count#test=2 foo=\"bar\" size=10\n"))
+	close(stop)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+		assert.NotEmpty(t, added)
+	case <-time.After(time.Second):
+		t.Fatal("Parse did not stop")
+	}
+}
+
+func TestApplication_ParseReturnsReadErrors(t *testing.T) {
+	r, w := io.Pipe()
+	db := new(mockDB)
+	s := new(mockStore)
+	s.On("Add", mock.Anything).Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	opts := snatch.ParseOpts{BufferSize: 1000, AllowedPending: 2}
+	go func() {
+		_, _ = w.Write([]byte("lvl=info msg= count#test=2 foo=\"bar\" size=10\n"))
+		_ = w.CloseWithError(errors.New("test"))
+	}()
+
+	err := app.Parse(r, opts, func(b []byte) {})
+
+	assert.EqualError(t, err, "test")
+	s.AssertExpectations(t)
+}
+
 func TestApplication_Scan(t *testing.T) {
 	out := make(chan *snatch.Bucket, 1)
 	out <- &snatch.Bucket{}
@@ -124,3 +229,321 @@ func TestApplication_FlushError(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+type mockCommitStore struct {
+	mockStore
+}
+
+func (m *mockCommitStore) Commit() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func TestApplication_ScanCommits(t *testing.T) {
+	out := make(chan *snatch.Bucket, 1)
+	out <- &snatch.Bucket{}
+	close(out)
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	s := new(mockCommitStore)
+	s.On("Scan").Return(out, nil)
+	s.On("Commit").Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	err := app.Scan()
+
+	assert.NoError(t, err)
+	s.AssertExpectations(t)
+}
+
+func TestApplication_ScanDoesNotCommitOnInsertError(t *testing.T) {
+	out := make(chan *snatch.Bucket, 1)
+	out <- &snatch.Bucket{}
+	close(out)
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(errors.New("test"))
+	s := new(mockCommitStore)
+	s.On("Scan").Return(out, nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	err := app.Scan()
+
+	assert.Error(t, err)
+	s.AssertNotCalled(t, "Commit")
+}
+
+func TestApplication_Snapshot(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now.Add(11 * time.Second))
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock))
+	_ = s.Add(&snatch.Bucket{
+		ID:   &snatch.ID{Time: now, Name: "done", Type: snatch.Count},
+		Vals: []float64{1},
+		Sum:  1,
+	}, &snatch.Bucket{
+		ID:   &snatch.ID{Time: now.Add(10 * time.Second), Name: "partial", Type: snatch.Count},
+		Vals: []float64{1},
+		Sum:  1,
+	})
+	db := new(mockDB)
+	db.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 1 && bkts[0].ID.Name == "done"
+	})).Return(nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	buf := &bytes.Buffer{}
+
+	err := app.Snapshot(buf)
+
+	assert.NoError(t, err)
+	db.AssertExpectations(t)
+
+	s = snatch.NewStore(10*time.Second, snatch.WithClock(clock))
+	app = snatch.NewApplication(10*time.Second, db, s)
+
+	err = app.Restore(buf)
+
+	assert.NoError(t, err)
+	out, _ := s.Flush()
+	if assert.Len(t, out, 1) {
+		assert.Equal(t, "partial", (<-out).ID.Name)
+	}
+}
+
+func TestApplication_SnapshotUnsupportedStore(t *testing.T) {
+	db := new(mockDB)
+	s := new(mockStore)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	assert.Error(t, app.Snapshot(&bytes.Buffer{}))
+	assert.Error(t, app.Restore(&bytes.Buffer{}))
+}
+
+// notifyClock is a FakeClock that signals each wait on it.
+type notifyClock struct {
+	*snatch.FakeClock
+
+	waited chan struct{}
+}
+
+func newNotifyClock(now time.Time) *notifyClock {
+	return &notifyClock{FakeClock: snatch.NewFakeClock(now), waited: make(chan struct{}, 10)}
+}
+
+func (c *notifyClock) After(d time.Duration) <-chan time.Time {
+	ch := c.FakeClock.After(d)
+	c.waited <- struct{}{}
+	return ch
+}
+
+// notifyStore is a Store that signals each add to it.
+type notifyStore struct {
+	snatch.Store
+
+	added chan struct{}
+}
+
+func newNotifyStore(s snatch.Store) *notifyStore {
+	return &notifyStore{Store: s, added: make(chan struct{}, 10)}
+}
+
+func (s *notifyStore) Add(bkts ...*snatch.Bucket) error {
+	err := s.Store.Add(bkts...)
+	s.added <- struct{}{}
+	return err
+}
+
+func TestApplication_Run(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := newNotifyClock(now)
+	r, w := io.Pipe()
+	defer w.Close()
+
+	inserted := make(chan []*snatch.Bucket, 10)
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Run(func(args mock.Arguments) {
+		inserted <- args.Get(0).([]*snatch.Bucket)
+	}).Return(nil)
+	s := newNotifyStore(snatch.NewStore(10*time.Second, snatch.WithClock(clock)))
+	app := snatch.NewApplication(10*time.Second, db, s, snatch.WithClock(clock))
+	opts := snatch.RunOpts{ParseOpts: snatch.ParseOpts{BufferSize: 1, AllowedPending: 10}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- app.Run(ctx, r, opts)
+	}()
+
+	_, _ = w.Write([]byte("count#test=2\n"))
+	<-s.added
+	<-clock.waited
+	clock.Add(11 * time.Second)
+	if bkts := <-inserted; assert.Len(t, bkts, 1) {
+		assert.Equal(t, float64(2), bkts[0].Sum)
+	}
+
+	_, _ = w.Write([]byte("count#test=3\n"))
+	<-s.added
+	cancel()
+
+	assert.NoError(t, <-done)
+	if bkts := <-inserted; assert.Len(t, bkts, 1) {
+		assert.Equal(t, float64(3), bkts[0].Sum)
+	}
+}
+
+func TestApplication_RunReturnsScanErrors(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := newNotifyClock(now)
+	r, w := io.Pipe()
+	defer w.Close()
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(errors.New("test"))
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock))
+	app := snatch.NewApplication(10*time.Second, db, s, snatch.WithClock(clock))
+	final := false
+	opts := snatch.RunOpts{
+		ParseOpts: snatch.ParseOpts{BufferSize: 1, AllowedPending: 10},
+		Final: func() error {
+			final = true
+			return nil
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- app.Run(context.Background(), r, opts)
+	}()
+	<-clock.waited
+	clock.Add(11 * time.Second)
+
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "test")
+		assert.True(t, final)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestApplication_RunFlushesAtEOF(t *testing.T) {
+	b := []byte("count#test=2\ninvalid\n")
+
+	db := new(mockDB)
+	db.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 1
+	})).Return(nil)
+	s := snatch.NewStore(10 * time.Second)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	var invalid []byte
+	opts := snatch.RunOpts{
+		ParseOpts: snatch.ParseOpts{BufferSize: 1, AllowedPending: 10},
+		InvalidLine: func(b []byte) {
+			invalid = append(invalid, b...)
+		},
+	}
+
+	err := app.Run(context.Background(), bytes.NewReader(b), opts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("invalid\n"), invalid)
+	db.AssertExpectations(t)
+}
+
+func TestApplication_Stats(t *testing.T) {
+	b := []byte("count#test=2\ncount#test=test\nfoo=bar\ninvalid=\"\n")
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	s := snatch.NewStore(10 * time.Second)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	opts := snatch.ParseOpts{BufferSize: 10, AllowedPending: 10}
+
+	err := app.Parse(bytes.NewReader(b), opts, func([]byte) {})
+	assert.NoError(t, err)
+	err = app.Flush()
+	assert.NoError(t, err)
+
+	stats := app.Stats()
+	assert.Equal(t, int64(4), stats.LinesRead)
+	assert.Equal(t, int64(1), stats.LinesParsed)
+	assert.Equal(t, map[string]int64{
+		snatch.ReasonValue:     1,
+		snatch.ReasonNoMetrics: 1,
+		snatch.ReasonSyntax:    1,
+	}, stats.ParseErrors)
+	assert.Equal(t, 1, stats.Series)
+	assert.Equal(t, int64(1), stats.Inserts)
+	assert.Equal(t, int64(0), stats.InsertFailures)
+	assert.Equal(t, 0, stats.Store.Series)
+}
+
+func TestApplication_StatsCountsInsertFailures(t *testing.T) {
+	out := make(chan *snatch.Bucket)
+	close(out)
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(errors.New("test"))
+	s := new(mockStore)
+	s.On("Scan").Return(out, nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	_ = app.Scan()
+
+	stats := app.Stats()
+	assert.Equal(t, int64(1), stats.Inserts)
+	assert.Equal(t, int64(1), stats.InsertFailures)
+}
+
+func TestApplication_SelfMetrics(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 11, 0, time.UTC)
+	clock := snatch.NewFakeClock(now)
+
+	var inserted [][]*snatch.Bucket
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Run(func(args mock.Arguments) {
+		inserted = append(inserted, args.Get(0).([]*snatch.Bucket))
+	}).Return(nil)
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock))
+	app := snatch.NewApplication(10*time.Second, db, s, snatch.WithClock(clock), snatch.WithSelfMetrics("snatch."))
+	opts := snatch.ParseOpts{BufferSize: 10, AllowedPending: 10}
+
+	_ = app.Parse(bytes.NewReader([]byte("count#test=2\ninvalid\n")), opts, func([]byte) {})
+	_ = app.Flush()
+	_ = app.Scan()
+	clock.Add(10 * time.Second)
+	_ = app.Parse(bytes.NewReader([]byte("count#test=2\n")), opts, func([]byte) {})
+	_ = app.Flush()
+
+	values := func(bkts []*snatch.Bucket, ts time.Time) map[string]float64 {
+		m := map[string]float64{}
+		for _, bkt := range bkts {
+			if strings.HasPrefix(bkt.ID.Name, "snatch.") {
+				assert.Equal(t, ts, bkt.ID.Time)
+			}
+			m[bkt.ID.Name+strings.Join(bkt.ID.Tags, ",")] = bkt.Sum
+		}
+		return m
+	}
+
+	if assert.Len(t, inserted, 3) {
+		first := values(inserted[0], now.Add(-11*time.Second))
+		assert.Equal(t, float64(2), first["test"])
+		assert.Equal(t, float64(2), first["snatch.lines.read"])
+		assert.Equal(t, float64(1), first["snatch.lines.parsed"])
+		assert.Equal(t, float64(1), first["snatch.parse.errorsreason,no_metrics"])
+		assert.Equal(t, float64(0), first["snatch.series"])
+		assert.Contains(t, first, "snatch.store.series")
+
+		// The interval was already reported.
+		assert.Empty(t, inserted[1])
+
+		third := values(inserted[2], now.Add(-1*time.Second))
+		assert.Equal(t, float64(1), third["snatch.lines.read"])
+		assert.Equal(t, float64(0), third["snatch.parse.errorsreason,no_metrics"])
+		assert.Equal(t, float64(0), third["snatch.series"])
+		assert.Contains(t, third, "snatch.insert.latency")
+	}
+}
@@ -3,6 +3,8 @@ package snatch
 import (
 	"strings"
 	"time"
+
+	"github.com/nrwiersma/snatch/utils"
 )
 
 // Type constants.
@@ -21,11 +23,27 @@ type ID struct {
 	Name string
 	Tags []string
 	Type Type
+	// Res is the resolution of the ID. A zero Res uses the
+	// resolution of the Store.
+	Res time.Duration
+	// Late is set when the ID arrived after its interval was emitted.
+	Late bool
+	// Rollup is set when the ID is rolled up from finer Buckets.
+	Rollup bool
 }
 
 // Keys returns the timestamp and key of an ID.
 func (id *ID) Keys() (int64, string) {
 	s := string(id.Type) + ":" + id.Name + ":" + strings.Join(id.Tags, ",")
+	if id.Res > 0 {
+		s += "@" + id.Res.String()
+	}
+	if id.Rollup {
+		s += "!rollup"
+	}
+	if id.Late {
+		s += "!late"
+	}
 
 	return id.Time.Unix(), s
 }
@@ -40,6 +58,12 @@ type Bucket struct {
 	Vals []float64
 	// Sum is the sum of the values.
 	Sum float64
+	// Relative is set when the values of a Sample are adjustments
+	// to its current value.
+	Relative bool
+	// Merged is set when the Bucket was emitted before, and is emitted
+	// again with late data merged into it.
+	Merged bool
 }
 
 // Append adds a metric value to the bucket.
@@ -54,3 +78,94 @@ func (b *Bucket) Merge(v *Bucket) {
 		b.Append(v)
 	}
 }
+
+// SampleFields returns the fields of a Sample Bucket for the aggregation.
+//
+// A single aggregation is returned as the "value" field, while
+// SampleAll returns each aggregation as a field.
+func (b *Bucket) SampleFields(agg SampleAgg) map[string]float64 {
+	if len(b.Vals) == 0 {
+		return map[string]float64{}
+	}
+
+	min, max := b.Vals[0], b.Vals[0]
+	for _, v := range b.Vals {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	all := map[string]float64{
+		string(SampleFirst): b.Vals[0],
+		string(SampleLast):  b.Vals[len(b.Vals)-1],
+		string(SampleMin):   min,
+		string(SampleMax):   max,
+		string(SampleMean):  b.Sum / float64(len(b.Vals)),
+	}
+	if agg == SampleAll {
+		return all
+	}
+
+	v, ok := all[string(agg)]
+	if !ok {
+		v = all[string(SampleLast)]
+	}
+	return map[string]float64{"value": v}
+}
+
+// Fields returns the aggregated fields of the Bucket, as written to
+// the database.
+func (b *Bucket) Fields(agg SampleAgg) map[string]interface{} {
+	v := map[string]interface{}{}
+
+	switch b.ID.Type {
+	case Count:
+		v["value"] = int64(b.Sum)
+
+	case Sample:
+		for k, f := range b.SampleFields(agg) {
+			v[k] = f
+		}
+
+	case Measure:
+		v["90_percentile"] = utils.Percentile(b.Vals, 90)
+		v["95_percentile"] = utils.Percentile(b.Vals, 95)
+		v["97_percentile"] = utils.Percentile(b.Vals, 97)
+		v["99_percentile"] = utils.Percentile(b.Vals, 99)
+		v["count"] = len(b.Vals)
+		v["lower"] = utils.Min(b.Vals)
+		v["mean"] = b.Sum / float64(len(b.Vals))
+		v["sum"] = b.Sum
+		v["upper"] = utils.Max(b.Vals)
+	}
+
+	return v
+}
+
+// clone returns a copy of the Bucket.
+func (b *Bucket) clone() *Bucket {
+	id := *b.ID
+
+	bkt := &Bucket{
+		ID:       &id,
+		Units:    b.Units,
+		Relative: b.Relative,
+		Merged:   b.Merged,
+	}
+	bkt.Merge(b)
+
+	return bkt
+}
+
+// rollup returns a copy of the Bucket at the given coarser resolution.
+func (b *Bucket) rollup(res time.Duration) *Bucket {
+	bkt := b.clone()
+	bkt.ID.Time = bkt.ID.Time.Truncate(res)
+	bkt.ID.Res = res
+	bkt.ID.Rollup = true
+
+	return bkt
+}
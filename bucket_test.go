@@ -22,6 +22,20 @@ func TestId_Keys(t *testing.T) {
 	assert.Equal(t, "counter:test:foo,bar", key)
 }
 
+func TestId_KeysWithRes(t *testing.T) {
+	id := &snatch.ID{
+		Time: time.Unix(414631410, 0),
+		Name: "test",
+		Tags: []string{"foo", "bar"},
+		Type: "counter",
+		Res:  time.Minute,
+	}
+
+	_, key := id.Keys()
+
+	assert.Equal(t, "counter:test:foo,bar@1m0s", key)
+}
+
 func BenchmarkID_Keys(b *testing.B) {
 	id := &snatch.ID{
 		Time: time.Unix(414631410, 0),
@@ -87,3 +101,71 @@ func BenchmarkBucket_Merge(b *testing.B) {
 		bkt.Merge(bkt2)
 	}
 }
+
+func TestBucket_SampleFields(t *testing.T) {
+	tests := []struct {
+		agg  snatch.SampleAgg
+		want map[string]float64
+	}{
+		{agg: snatch.SampleLast, want: map[string]float64{"value": 2}},
+		{agg: snatch.SampleFirst, want: map[string]float64{"value": 3}},
+		{agg: snatch.SampleMin, want: map[string]float64{"value": 1}},
+		{agg: snatch.SampleMax, want: map[string]float64{"value": 4}},
+		{agg: snatch.SampleMean, want: map[string]float64{"value": 2.5}},
+		{
+			agg: snatch.SampleAll,
+			want: map[string]float64{
+				"first": 3,
+				"last":  2,
+				"min":   1,
+				"max":   4,
+				"mean":  2.5,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b := &snatch.Bucket{}
+		b.Append(3)
+		b.Append(1)
+		b.Append(4)
+		b.Append(2)
+
+		assert.Equal(t, tt.want, b.SampleFields(tt.agg), string(tt.agg))
+		assert.Equal(t, []float64{3, 1, 4, 2}, b.Vals)
+	}
+}
+
+func TestBucket_Fields(t *testing.T) {
+	tests := []struct {
+		typ  snatch.Type
+		want map[string]interface{}
+	}{
+		{typ: snatch.Count, want: map[string]interface{}{"value": int64(10)}},
+		{typ: snatch.Sample, want: map[string]interface{}{"value": float64(2)}},
+		{
+			typ: snatch.Measure,
+			want: map[string]interface{}{
+				"90_percentile": float64(4),
+				"95_percentile": float64(4),
+				"97_percentile": float64(4),
+				"99_percentile": float64(4),
+				"count":         4,
+				"lower":         float64(1),
+				"mean":          2.5,
+				"sum":           float64(10),
+				"upper":         float64(4),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b := &snatch.Bucket{ID: &snatch.ID{Type: tt.typ}}
+		b.Append(3)
+		b.Append(1)
+		b.Append(4)
+		b.Append(2)
+
+		assert.Equal(t, tt.want, b.Fields(snatch.SampleLast), string(tt.typ))
+	}
+}
@@ -0,0 +1,348 @@
+package snatch
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"os"
+	"time"
+)
+
+// BudgetPolicy represents the handling of a Store exceeding its budget.
+type BudgetPolicy string
+
+// BudgetPolicy constants.
+const (
+	// BudgetFlush flushes the oldest complete intervals early, and drops
+	// Buckets of new series when only open intervals are held.
+	BudgetFlush BudgetPolicy = "flush"
+	// BudgetDrop drops Buckets of new series.
+	BudgetDrop BudgetPolicy = "drop"
+	// BudgetSpill spills the oldest intervals to disk until they are complete.
+	BudgetSpill BudgetPolicy = "spill"
+)
+
+// ParseBudgetPolicy parses a budget policy.
+func ParseBudgetPolicy(s string) (BudgetPolicy, error) {
+	switch p := BudgetPolicy(s); p {
+	case BudgetFlush, BudgetDrop, BudgetSpill:
+		return p, nil
+	}
+
+	return "", errors.New("snatch: invalid budget policy: " + s)
+}
+
+// Budget represents the memory budget of a Store.
+type Budget struct {
+	// MaxSeries is the maximum number of Buckets held, or zero for no limit.
+	MaxSeries int
+	// MaxBytes is the maximum estimated bytes held, or zero for no limit.
+	MaxBytes int64
+	// Policy is the handling of the Store exceeding its budget.
+	Policy BudgetPolicy
+	// SpillPath is the file Buckets are spilled to by BudgetSpill. Any
+	// existing file is truncated when Buckets are first spilled.
+	SpillPath string
+}
+
+// WithBudget configures the memory budget of the Store.
+func WithBudget(b Budget) Option {
+	return func(o *options) {
+		o.budget = b
+	}
+}
+
+// StoreStats represents the state of a Store.
+type StoreStats struct {
+	// Series is the number of Buckets held.
+	Series int
+	// Bytes is the estimated number of bytes held.
+	Bytes int64
+	// Evicted is the number of Buckets flushed early or spilled.
+	Evicted int64
+	// Early is the number of Buckets flushed early that have not
+	// been scanned yet.
+	Early int
+	// Dropped is the number of Buckets dropped due to the budget.
+	Dropped int64
+	// Late is the number of Buckets dropped for arriving after their
+	// interval was emitted.
+	Late int64
+}
+
+// StatsReporter is implemented by Stores that report their state.
+type StatsReporter interface {
+	// Stats returns the state of the Store.
+	Stats() StoreStats
+}
+
+// Stats returns the state of the Store.
+func (s *memStore) Stats() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+// bucketSize estimates the number of bytes held by a Bucket.
+func bucketSize(bkt *Bucket) int64 {
+	size := int64(128 + len(bkt.ID.Name) + len(bkt.Units) + 8*len(bkt.Vals))
+	for _, tag := range bkt.ID.Tags {
+		size += int64(16 + len(tag))
+	}
+
+	return size
+}
+
+// overBudget determines if the store exceeds its budget.
+func (s *memStore) overBudget(series int, bytes int64) bool {
+	return (s.budget.MaxSeries > 0 && series > s.budget.MaxSeries) ||
+		(s.budget.MaxBytes > 0 && bytes > s.budget.MaxBytes)
+}
+
+// evicts determines if the store evicts Buckets to fit its budget.
+func (s *memStore) evicts() bool {
+	return s.budget.Policy == BudgetFlush || s.budget.Policy == BudgetSpill
+}
+
+// putStore adds the Bucket to the store, accounting for its size.
+func (s *memStore) putStore(bkt *Bucket) {
+	if held := put(s.store, bkt); held != bkt {
+		s.stats.Bytes += int64(8 * len(bkt.Vals))
+		return
+	}
+
+	s.stats.Series++
+	s.stats.Bytes += bucketSize(bkt)
+
+	if s.evicts() {
+		end := s.endOf(bkt.ID).UnixNano()
+		if s.byEnd[end] == nil {
+			s.byEnd[end] = map[*Bucket]bool{}
+			heap.Push(&s.ends, end)
+		}
+		s.byEnd[end][bkt] = true
+	}
+}
+
+// removed accounts for a Bucket removed from the store.
+func (s *memStore) removed(bkt *Bucket) {
+	s.stats.Series--
+	s.stats.Bytes -= bucketSize(bkt)
+
+	if s.evicts() {
+		end := s.endOf(bkt.ID).UnixNano()
+		delete(s.byEnd[end], bkt)
+		if len(s.byEnd[end]) == 0 {
+			delete(s.byEnd, end)
+		}
+	}
+}
+
+// admit determines if the Bucket fits in the budget, dropping Buckets
+// of new series under BudgetDrop, and under BudgetFlush when no complete
+// interval can be flushed to make room for them.
+func (s *memStore) admit(bkt *Bucket) bool {
+	if s.budget.Policy != BudgetDrop && s.budget.Policy != BudgetFlush {
+		return true
+	}
+
+	ts, key := bkt.ID.Keys()
+	if _, ok := s.store[ts][key]; ok {
+		return true
+	}
+
+	size := bucketSize(bkt)
+	if s.budget.Policy == BudgetFlush {
+		s.evict(1, size)
+	}
+
+	if s.overBudget(s.stats.Series+1, s.stats.Bytes+size) {
+		s.stats.Dropped++
+		return false
+	}
+
+	return true
+}
+
+// enforce evicts Buckets until the store fits its budget.
+func (s *memStore) enforce() {
+	if !s.evicts() {
+		return
+	}
+
+	s.evict(0, 0)
+}
+
+// evict evicts the oldest Buckets, by the end of their interval, until
+// the store with the additional series and bytes fits its budget. Open
+// rollups end after the finer Buckets they are built from, so they are
+// evicted last.
+//
+// Open intervals are spilled but never flushed early, as their partial
+// Buckets would be overwritten or duplicated by the complete ones.
+func (s *memStore) evict(series int, bytes int64) {
+	complete := s.clock.Now().Add(-1 * s.lateness)
+	for s.overBudget(s.stats.Series+series, s.stats.Bytes+bytes) {
+		end, ok := s.oldestEnd()
+		if !ok {
+			return
+		}
+		open := end.After(complete)
+		if open && s.budget.Policy == BudgetFlush {
+			return
+		}
+
+		var bkts []*Bucket
+		for bkt := range s.byEnd[end.UnixNano()] {
+			ts, key := bkt.ID.Keys()
+			delete(s.store[ts], key)
+			if len(s.store[ts]) == 0 {
+				delete(s.store, ts)
+			}
+
+			s.removed(bkt)
+			bkts = append(bkts, bkt)
+		}
+
+		if s.budget.Policy == BudgetSpill {
+			err := s.spill(bkts)
+			if err == nil {
+				s.stats.Evicted += int64(len(bkts))
+				continue
+			}
+			if open {
+				// Keep open intervals that cannot be spilled.
+				for _, bkt := range bkts {
+					s.putStore(bkt)
+				}
+				return
+			}
+			// Flush complete intervals early when they cannot be spilled.
+		}
+		s.stats.Evicted += int64(len(bkts))
+
+		for _, bkt := range bkts {
+			if r := s.rollupOf(s.resOf(bkt.ID)); r > 0 && !bkt.ID.Late {
+				s.putStore(bkt.rollup(r))
+			}
+			if s.policy == LateMerge && !bkt.ID.Late {
+				put(s.closed, bkt)
+				bkt = bkt.clone()
+			}
+
+			s.early = append(s.early, bkt)
+		}
+		s.stats.Early = len(s.early)
+	}
+}
+
+// oldestEnd returns the earliest end of the intervals in the store.
+func (s *memStore) oldestEnd() (time.Time, bool) {
+	for len(s.ends) > 0 {
+		// Ends of removed Buckets are left in the heap until they are
+		// the oldest.
+		if end := s.ends[0]; s.byEnd[end] != nil {
+			return time.Unix(0, end), true
+		}
+		heap.Pop(&s.ends)
+	}
+
+	return time.Time{}, false
+}
+
+// endOf returns the end of the interval of the ID.
+func (s *memStore) endOf(id *ID) time.Time {
+	return id.Time.Add(s.resOf(id))
+}
+
+// endHeap is a min-heap of interval ends, in unix nanoseconds.
+type endHeap []int64
+
+func (h endHeap) Len() int           { return len(h) }
+func (h endHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h endHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *endHeap) Push(x interface{}) {
+	*h = append(*h, x.(int64))
+}
+
+func (h *endHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
+
+// spill appends the Buckets to the spill file. A file left over from
+// a previous store is truncated.
+func (s *memStore) spill(bkts []*Bucket) error {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !s.spilled {
+		flag |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(s.budget.SpillPath, flag, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := writeRecords(w, bkts); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	s.spilled = true
+
+	return f.Close()
+}
+
+// spilledBuckets returns the spilled Buckets.
+func (s *memStore) spilledBuckets() ([]*Bucket, error) {
+	if !s.spilled {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.budget.SpillPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var bkts []*Bucket
+	err = readRecords(bufio.NewReader(f), func(bkt *Bucket) {
+		bkts = append(bkts, bkt)
+	})
+
+	return bkts, err
+}
+
+// unspill moves the spilled Buckets that are ready back into the store.
+func (s *memStore) unspill(ready func(res time.Duration, ts int64) bool) error {
+	bkts, err := s.spilledBuckets()
+	if err != nil || len(bkts) == 0 {
+		return err
+	}
+
+	var pending []*Bucket
+	for _, bkt := range bkts {
+		if !ready(s.resOf(bkt.ID), bkt.ID.Time.Unix()) {
+			pending = append(pending, bkt)
+			continue
+		}
+
+		s.putStore(bkt)
+	}
+
+	s.spilled = false
+	if len(pending) > 0 {
+		return s.spill(pending)
+	}
+
+	return os.Remove(s.budget.SpillPath)
+}
@@ -0,0 +1,222 @@
+package snatch_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBudgetPolicy(t *testing.T) {
+	for _, s := range []string{"flush", "drop", "spill"} {
+		p, err := snatch.ParseBudgetPolicy(s)
+
+		assert.NoError(t, err)
+		assert.Equal(t, snatch.BudgetPolicy(s), p)
+	}
+
+	_, err := snatch.ParseBudgetPolicy("foo")
+
+	assert.Error(t, err)
+}
+
+func newBudgetBucket(ts time.Time, name string) *snatch.Bucket {
+	bkt := &snatch.Bucket{
+		ID: &snatch.ID{Time: ts, Name: name, Type: snatch.Count},
+	}
+	bkt.Append(1)
+
+	return bkt
+}
+
+func TestMemStore_Stats(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	s := snatch.NewStore(10 * time.Second)
+
+	_ = s.Add(newBudgetBucket(now, "foo"), newBudgetBucket(now, "foo"), newBudgetBucket(now, "bar"))
+
+	stats := s.(snatch.StatsReporter).Stats()
+	assert.Equal(t, 2, stats.Series)
+	assert.True(t, stats.Bytes > 0)
+
+	out, _ := s.Flush()
+	assert.Len(t, out, 2)
+	assert.Equal(t, snatch.StoreStats{}, s.(snatch.StatsReporter).Stats())
+}
+
+func TestMemStore_BudgetDrop(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	s := snatch.NewStore(10*time.Second, snatch.WithBudget(snatch.Budget{
+		MaxSeries: 2,
+		Policy:    snatch.BudgetDrop,
+	}))
+
+	_ = s.Add(
+		newBudgetBucket(now, "foo"),
+		newBudgetBucket(now, "bar"),
+		newBudgetBucket(now, "baz"),
+		newBudgetBucket(now, "foo"),
+	)
+
+	stats := s.(snatch.StatsReporter).Stats()
+	assert.Equal(t, 2, stats.Series)
+	assert.Equal(t, int64(1), stats.Dropped)
+	out, _ := s.Flush()
+	sums := map[string]float64{}
+	for bkt := range out {
+		sums[bkt.ID.Name] = bkt.Sum
+	}
+	assert.Equal(t, map[string]float64{"foo": 2, "bar": 1}, sums)
+}
+
+func TestMemStore_BudgetFlush(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now.Add(time.Second))
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock), snatch.WithBudget(snatch.Budget{
+		MaxSeries: 2,
+		Policy:    snatch.BudgetFlush,
+	}))
+
+	_ = s.Add(
+		newBudgetBucket(now.Add(-10*time.Second), "foo"),
+		newBudgetBucket(now, "foo"),
+		newBudgetBucket(now, "bar"),
+	)
+
+	stats := s.(snatch.StatsReporter).Stats()
+	assert.Equal(t, 2, stats.Series)
+	assert.Equal(t, int64(1), stats.Evicted)
+	assert.Equal(t, 1, stats.Early)
+	out, _ := s.Scan()
+	if assert.Len(t, out, 1) {
+		assert.Equal(t, now.Add(-10*time.Second), (<-out).ID.Time)
+	}
+	assert.Equal(t, 0, s.(snatch.StatsReporter).Stats().Early)
+}
+
+func TestMemStore_BudgetFlushDropsWhenOnlyOpenIntervalsAreHeld(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now)
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock), snatch.WithBudget(snatch.Budget{
+		MaxSeries: 2,
+		Policy:    snatch.BudgetFlush,
+	}))
+
+	_ = s.Add(
+		newBudgetBucket(now.Add(-10*time.Second), "foo"),
+		newBudgetBucket(now, "foo"),
+		newBudgetBucket(now, "bar"),
+		newBudgetBucket(now, "foo"),
+	)
+
+	stats := s.(snatch.StatsReporter).Stats()
+	assert.Equal(t, 2, stats.Series)
+	assert.Equal(t, int64(0), stats.Evicted)
+	assert.Equal(t, int64(1), stats.Dropped)
+	out, _ := s.Flush()
+	sums := map[string]float64{}
+	for bkt := range out {
+		sums[bkt.ID.Name+"@"+bkt.ID.Time.Format("15:04:05")] = bkt.Sum
+	}
+	assert.Equal(t, map[string]float64{"foo@10:09:50": 1, "foo@10:10:00": 2}, sums)
+}
+
+func TestMemStore_BudgetSpill(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now)
+	s := snatch.NewStore(10*time.Second, snatch.WithClock(clock), snatch.WithBudget(snatch.Budget{
+		MaxSeries: 1,
+		Policy:    snatch.BudgetSpill,
+		SpillPath: filepath.Join(t.TempDir(), "spill.log"),
+	}))
+
+	_ = s.Add(newBudgetBucket(now, "foo"), newBudgetBucket(now.Add(10*time.Second), "foo"))
+	assert.Equal(t, 1, s.(snatch.StatsReporter).Stats().Series)
+	_ = s.Add(newBudgetBucket(now, "foo"))
+	assert.Equal(t, 1, s.(snatch.StatsReporter).Stats().Series)
+
+	out, _ := s.Scan()
+	assert.Len(t, out, 0)
+
+	clock.Add(11 * time.Second)
+	out, _ = s.Scan()
+	if assert.Len(t, out, 1) {
+		bkt := <-out
+		assert.True(t, now.Equal(bkt.ID.Time))
+		assert.Equal(t, float64(2), bkt.Sum)
+	}
+
+	out, _ = s.Flush()
+	if assert.Len(t, out, 1) {
+		assert.True(t, now.Add(10*time.Second).Equal((<-out).ID.Time))
+	}
+}
+
+func TestMemStore_BudgetFlushEvictsOpenRollupsLast(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now.Add(11 * time.Second))
+	s := snatch.NewStore(10*time.Second,
+		snatch.WithClock(clock),
+		snatch.WithLateness(0),
+		snatch.WithRollups(snatch.Rollup{Res: time.Minute}),
+		snatch.WithBudget(snatch.Budget{
+			MaxSeries: 2,
+			Policy:    snatch.BudgetFlush,
+		}),
+	)
+	_ = s.Add(newBudgetBucket(now, "foo"))
+	out, _ := s.Scan()
+	assert.Len(t, out, 1)
+
+	clock.Add(10 * time.Second)
+	_ = s.Add(
+		newBudgetBucket(now.Add(10*time.Second), "foo"),
+		newBudgetBucket(now.Add(20*time.Second), "foo"),
+	)
+
+	out, _ = s.Scan()
+	if assert.Len(t, out, 1) {
+		bkt := <-out
+		assert.True(t, now.Add(10*time.Second).Equal(bkt.ID.Time))
+	}
+}
+
+func TestMemStore_BudgetSpillTruncatesStaleFile(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "spill.log")
+	_ = ioutil.WriteFile(path, []byte(`{"t":414631410000000000,"n":"stale","y":"count","v":[1]}`+"\n"), 0644)
+	s := snatch.NewStore(10*time.Second, snatch.WithBudget(snatch.Budget{
+		MaxSeries: 1,
+		Policy:    snatch.BudgetSpill,
+		SpillPath: path,
+	}))
+
+	_ = s.Add(newBudgetBucket(now, "foo"), newBudgetBucket(now.Add(10*time.Second), "foo"))
+
+	out, err := s.Flush()
+	assert.NoError(t, err)
+	names := []string{}
+	for bkt := range out {
+		names = append(names, bkt.ID.Name)
+	}
+	assert.Equal(t, []string{"foo", "foo"}, names)
+}
+
+func TestMemStore_ScanErrorsOnCorruptSpill(t *testing.T) {
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "spill.log")
+	s := snatch.NewStore(10*time.Second, snatch.WithBudget(snatch.Budget{
+		MaxSeries: 1,
+		Policy:    snatch.BudgetSpill,
+		SpillPath: path,
+	}))
+	_ = s.Add(newBudgetBucket(now, "foo"), newBudgetBucket(now.Add(10*time.Second), "foo"))
+	_ = ioutil.WriteFile(path, []byte("foo\n"), 0644)
+
+	_, err := s.Scan()
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package snatch
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock represents a source of time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the
+	// current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// NewClock returns a Clock backed by the system time.
+func NewClock() Clock {
+	return systemClock{}
+}
+
+// Now returns the current time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse and then sends the
+// current time on the returned channel.
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type waiter struct {
+	t  time.Time
+	ch chan time.Time
+}
+
+// FakeClock is a Clock that only moves when it is told to.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// NewFakeClock creates a new FakeClock set to the given time.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After sends the time on the returned channel once the clock
+// has been moved past the duration.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, waiter{t: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Add moves the clock forward by the duration.
+func (c *FakeClock) Add(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the time of the clock, firing any waiters that are due.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.t.After(t) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		w.ch <- t
+	}
+	c.waiters = waiters
+}
+
+// Waiters returns the number of pending waiters.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
@@ -0,0 +1,50 @@
+package snatch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClock(t *testing.T) {
+	c := snatch.NewClock()
+
+	assert.Implements(t, (*snatch.Clock)(nil), c)
+	assert.WithinDuration(t, time.Now(), c.Now(), time.Second)
+}
+
+func TestFakeClock_Now(t *testing.T) {
+	now := time.Unix(414631410, 0)
+	c := snatch.NewFakeClock(now)
+
+	assert.Equal(t, now, c.Now())
+
+	c.Add(time.Minute)
+
+	assert.Equal(t, now.Add(time.Minute), c.Now())
+}
+
+func TestFakeClock_After(t *testing.T) {
+	now := time.Unix(414631410, 0)
+	c := snatch.NewFakeClock(now)
+
+	ch := c.After(10 * time.Second)
+
+	assert.Equal(t, 1, c.Waiters())
+	c.Add(5 * time.Second)
+	assert.Len(t, ch, 0)
+	c.Add(5 * time.Second)
+	assert.Equal(t, now.Add(10*time.Second), <-ch)
+	assert.Equal(t, 0, c.Waiters())
+}
+
+func TestFakeClock_AfterNow(t *testing.T) {
+	now := time.Unix(414631410, 0)
+	c := snatch.NewFakeClock(now)
+
+	ch := c.After(0)
+
+	assert.Equal(t, now, <-ch)
+}
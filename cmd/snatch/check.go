@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"gopkg.in/urfave/cli.v2"
+)
+
+const flagCheckFormat = "format"
+
+var checkCommand = &cli.Command{
+	Name:      "check",
+	Usage:     "Show the metrics a log would produce, without writing them to the database",
+	ArgsUsage: "[file]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  flagCheckFormat,
+			Value: "table",
+			Usage: "The output format: table or json",
+		},
+	},
+	Action: runCheck,
+}
+
+// checkSeries is a series produced by a check.
+type checkSeries struct {
+	Type   snatch.Type            `json:"type"`
+	Name   string                 `json:"name"`
+	Tags   map[string]string      `json:"tags,omitempty"`
+	Time   time.Time              `json:"time"`
+	Res    string                 `json:"res"`
+	Units  string                 `json:"units,omitempty"`
+	Fields map[string]interface{} `json:"fields"`
+
+	res time.Duration
+}
+
+// checkRejection is a line rejected by a check.
+type checkRejection struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+	Text   string `json:"text"`
+}
+
+// checkResult is the result of a check.
+type checkResult struct {
+	Series   []checkSeries    `json:"series"`
+	Rejected []checkRejection `json:"rejected"`
+}
+
+func runCheck(c *cli.Context) error {
+	cfg, err := newConfig(c)
+	if err != nil {
+		return err
+	}
+
+	format := c.String(flagCheckFormat)
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid format: %s", format)
+	}
+
+	r := io.Reader(os.Stdin)
+	if path := c.Args().First(); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	return writeCheck(os.Stdout, r, cfg, format)
+}
+
+// writeCheck checks the lines of the Reader, writing the result in the
+// format. If lines are rejected, an error exiting with status 1 is returned.
+func writeCheck(w io.Writer, r io.Reader, cfg *config, format string) error {
+	res, err := check(r, cfg)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(res)
+	} else {
+		err = writeCheckTable(w, res)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(res.Rejected) > 0 {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// check runs the lines through a Parser and Store, returning the
+// resulting series and the rejected lines. The Store has no budget,
+// so every series of the lines is shown and nothing is spilled.
+func check(r io.Reader, cfg *config) (*checkResult, error) {
+	p := snatch.NewParser(cfg.res, cfg.opts...)
+	opts := append(cfg.opts[:len(cfg.opts):len(cfg.opts)], snatch.WithBudget(snatch.Budget{}))
+	s := snatch.NewStore(cfg.res, opts...)
+	res := &checkResult{Series: []checkSeries{}, Rejected: []checkRejection{}}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		bkts, err := p.Parse(line)
+		if err != nil || len(bkts) == 0 {
+			rej := checkRejection{Line: n, Reason: snatch.ReasonNoMetrics, Text: string(line)}
+			if err != nil {
+				rej.Reason = snatch.ParseErrorReason(err)
+				rej.Error = err.Error()
+			}
+			res.Rejected = append(res.Rejected, rej)
+			continue
+		}
+
+		if err := s.Add(bkts...); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	out, err := s.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	for bkt := range out {
+		series := checkSeries{
+			Type:   bkt.ID.Type,
+			Name:   bkt.ID.Name,
+			Time:   bkt.ID.Time,
+			Res:    cfg.res.String(),
+			Units:  bkt.Units,
+			Fields: bkt.Fields(cfg.agg),
+			res:    cfg.res,
+		}
+		if bkt.ID.Res > 0 {
+			series.Res = bkt.ID.Res.String()
+			series.res = bkt.ID.Res
+		}
+		if len(bkt.ID.Tags) > 0 {
+			series.Tags = make(map[string]string, len(bkt.ID.Tags)/2)
+			for i := 0; i+1 < len(bkt.ID.Tags); i += 2 {
+				series.Tags[bkt.ID.Tags[i]] = bkt.ID.Tags[i+1]
+			}
+		}
+
+		res.Series = append(res.Series, series)
+	}
+
+	sort.SliceStable(res.Series, func(i, j int) bool {
+		a, b := res.Series[i], res.Series[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if ta, tb := joinPairs(a.Tags), joinPairs(b.Tags); ta != tb {
+			return ta < tb
+		}
+		if a.res != b.res {
+			return a.res < b.res
+		}
+		return a.Time.Before(b.Time)
+	})
+
+	return res, nil
+}
+
+// writeCheckTable writes the check result as tables.
+func writeCheckTable(w io.Writer, res *checkResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tTAGS\tTIME\tRES\tUNITS\tFIELDS")
+	for _, s := range res.Series {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Type, s.Name, joinPairs(s.Tags), s.Time.Format(time.RFC3339), s.Res, s.Units, joinFields(s.Fields))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(res.Rejected) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%d rejected lines\n", len(res.Rejected))
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tREASON\tERROR\tTEXT")
+	for _, r := range res.Rejected {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.Line, r.Reason, r.Error, r.Text)
+	}
+
+	return tw.Flush()
+}
+
+// joinPairs formats a map as sorted key=value pairs.
+func joinPairs(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// joinFields formats fields as sorted key=value pairs.
+func joinFields(m map[string]interface{}) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, " ")
+}
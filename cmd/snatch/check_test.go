@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/urfave/cli.v2"
+)
+
+// checkConfig returns the startup configuration for the config file.
+func checkConfig(t *testing.T, yaml string) *config {
+	cfg, err := loadConfig([]string{"snatch", "--config", writeConfigFile(t, yaml)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestCheck(t *testing.T) {
+	cfg := checkConfig(t, "res: 1m\nparser.event-time: true\n")
+	in := strings.Join([]string{
+		"t=2018-10-10T10:10:01Z count#req=1 host=a",
+		"t=2018-10-10T10:10:02Z count#req=2 host=a",
+		"t=2018-10-10T10:10:03Z sample#mem=10",
+		"",
+		"t=yesterday count#req=1",
+		"t=2018-10-10T10:10:04Z lvl=info msg=hello",
+	}, "\n")
+
+	res, err := check(strings.NewReader(in), cfg)
+
+	assert.NoError(t, err)
+	if assert.Len(t, res.Series, 2) {
+		assert.Equal(t, "mem", res.Series[0].Name)
+		assert.Equal(t, "req", res.Series[1].Name)
+		assert.Equal(t, map[string]string{"host": "a"}, res.Series[1].Tags)
+		assert.Equal(t, "1m0s", res.Series[1].Res)
+		assert.Equal(t, int64(3), res.Series[1].Fields["value"])
+	}
+	if assert.Len(t, res.Rejected, 2) {
+		assert.Equal(t, 5, res.Rejected[0].Line)
+		assert.Equal(t, snatch.ReasonTime, res.Rejected[0].Reason)
+		assert.NotEmpty(t, res.Rejected[0].Error)
+		assert.Equal(t, 6, res.Rejected[1].Line)
+		assert.Equal(t, snatch.ReasonNoMetrics, res.Rejected[1].Reason)
+		assert.Empty(t, res.Rejected[1].Error)
+	}
+}
+
+func TestCheckIgnoresBudget(t *testing.T) {
+	cfg := checkConfig(t, "budget.series: 1\nbudget.policy: drop\n")
+	in := "count#req=1\ncount#err=1\n"
+
+	res, err := check(strings.NewReader(in), cfg)
+
+	assert.NoError(t, err)
+	assert.Len(t, res.Series, 2)
+}
+
+func TestCheckSortsByResolution(t *testing.T) {
+	cfg := checkConfig(t, "res: 5s\nrollup: [10s]\n")
+	in := "count#req=1\n"
+
+	res, err := check(strings.NewReader(in), cfg)
+
+	assert.NoError(t, err)
+	if assert.Len(t, res.Series, 2) {
+		assert.Equal(t, "5s", res.Series[0].Res)
+		assert.Equal(t, "10s", res.Series[1].Res)
+	}
+}
+
+func TestWriteCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		format   string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:   "table",
+			in:     "count#req=1\n",
+			format: "table",
+			want:   []string{"count", "req", "value=1"},
+		},
+		{
+			name:     "table with rejects",
+			in:       "count#req=1\nlvl=info msg=hello\nlvl=info msg=world\n",
+			format:   "table",
+			wantCode: 1,
+			want:     []string{"2 rejected lines", "no_metrics         lvl=info msg=hello", "no_metrics         lvl=info msg=world"},
+		},
+		{
+			name:   "json",
+			in:     "count#req=1\n",
+			format: "json",
+			want:   []string{`"name": "req"`, `"rejected": []`},
+		},
+		{
+			name:     "json with rejects",
+			in:       "lvl=info msg=hello\n",
+			format:   "json",
+			wantCode: 1,
+			want:     []string{`"series": []`, `"reason": "no_metrics"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := checkConfig(t, "res: 1m\n")
+			buf := &bytes.Buffer{}
+
+			err := writeCheck(buf, strings.NewReader(tt.in), cfg, tt.format)
+
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				exit, ok := err.(cli.ExitCoder)
+				assert.True(t, ok)
+				assert.Equal(t, tt.wantCode, exit.ExitCode())
+			}
+			for _, want := range tt.want {
+				assert.Contains(t, buf.String(), want)
+			}
+			if tt.format == "json" {
+				assert.True(t, json.Valid(buf.Bytes()))
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"gopkg.in/urfave/cli.v2"
+)
+
+// config is the validated configuration of snatch.
+type config struct {
+	db       string
+	store    string
+	snapshot string
+	res      time.Duration
+	agg      snatch.SampleAgg
+	opts     []snatch.Option
+	parse    snatch.ParseOpts
+
+	// dbKey and storeKey describe the settings the database and store
+	// are created with, so a reload only replaces them when they change.
+	dbKey    string
+	storeKey string
+}
+
+// newConfig validates the configuration in the context.
+func newConfig(c *cli.Context) (*config, error) {
+	res := c.Duration(flagResolution)
+	if res <= 0 {
+		return nil, errors.New("invalid res: must be positive")
+	}
+
+	rollups, err := newRollups(res, c.StringSlice(flagRollup))
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := newResolutionRules(c.StringSlice(flagResRule), rollups)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := snatch.ParseLatePolicy(c.String(flagLatePolicy))
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := snatch.ParseSampleAgg(c.String(flagSampleAgg))
+	if err != nil {
+		return nil, err
+	}
+
+	budget, err := newBudget(c)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []snatch.Option{
+		snatch.WithRollups(rollups...),
+		snatch.WithResolutionRules(rules...),
+		snatch.WithLateness(c.Duration(flagLateness)),
+		snatch.WithLatePolicy(policy, c.Duration(flagLateHorizon)),
+		snatch.WithGapFill(c.Int(flagGapFill)),
+		snatch.WithSampleAggregation(agg),
+		snatch.WithBudget(budget),
+	}
+	if c.Bool(flagParserEventTime) {
+		opts = append(opts, snatch.WithEventTime())
+	}
+	if c.Bool(flagStats) {
+		opts = append(opts, snatch.WithSelfMetrics(c.String(flagStatsPrefix)))
+	}
+
+	if _, _, err := parseStore(c.String(flagStore)); err != nil {
+		return nil, err
+	}
+
+	return &config{
+		db:       c.String(flagDbDsn),
+		store:    c.String(flagStore),
+		snapshot: c.String(flagSnapshot),
+		res:      res,
+		agg:      agg,
+		opts:     opts,
+		parse: snatch.ParseOpts{
+			BufferSize:     c.Int(flagParserBatch),
+			AllowedPending: c.Int(flagParserAllowPending),
+		},
+		dbKey: fmt.Sprint(c.String(flagDbDsn), rollups, agg),
+		storeKey: fmt.Sprint(c.String(flagStore), res, rollups, c.Duration(flagLateness), policy,
+			c.Duration(flagLateHorizon), c.Int(flagGapFill), budget),
+	}, nil
+}
+
+// loadConfig reads and validates the configuration from the arguments
+// and the config file, as it is read on startup.
+func loadConfig(args []string) (*config, error) {
+	var cfg *config
+
+	app := newApp()
+	app.Writer = os.Stderr
+	app.Action = func(c *cli.Context) error {
+		var err error
+		cfg, err = newConfig(c)
+		return err
+	}
+	if err := app.Run(args); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
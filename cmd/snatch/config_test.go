@@ -0,0 +1,204 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/urfave/cli.v2"
+)
+
+// writeConfigFile writes a config file, returning its path.
+func writeConfigFile(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "snatch.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// withContext runs the app with the arguments, calling fn with the context.
+func withContext(t *testing.T, args []string, fn func(*cli.Context) error) error {
+	app := newApp()
+	app.Writer = ioutil.Discard
+	app.Action = fn
+
+	return app.Run(append([]string{"snatch"}, args...))
+}
+
+func TestEnvVars(t *testing.T) {
+	tests := []struct {
+		flag string
+		want string
+	}{
+		{flag: "db", want: "SNATCH_DB"},
+		{flag: "res-rule", want: "SNATCH_RES_RULE"},
+		{flag: "budget.spill", want: "SNATCH_BUDGET_SPILL"},
+		{flag: "parser.allow-pending", want: "SNATCH_PARSER_ALLOW_PENDING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			assert.Equal(t, []string{tt.want}, envVars(tt.flag))
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  string
+		args []string
+		want time.Duration
+	}{
+		{name: "default", want: 10 * time.Second},
+		{name: "config file", yaml: "res: 20s\n", want: 20 * time.Second},
+		{name: "env over config file", yaml: "res: 20s\n", env: "30s", want: 30 * time.Second},
+		{name: "flag over env", yaml: "res: 20s\n", env: "30s", args: []string{"--res=40s"}, want: 40 * time.Second},
+		{name: "flag over config file", yaml: "res: 20s\n", args: []string{"--res", "40s"}, want: 40 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("SNATCH_RES", tt.env)
+			}
+			args := append([]string{"snatch", "--config", writeConfigFile(t, tt.yaml)}, tt.args...)
+
+			cfg, err := loadConfig(args)
+
+			assert.NoError(t, err)
+			if assert.NotNil(t, cfg) {
+				assert.Equal(t, tt.want, cfg.res)
+			}
+		})
+	}
+}
+
+func TestLoadConfigReadsNestedKeys(t *testing.T) {
+	path := writeConfigFile(t, "parser:\n  batch: 100\n")
+
+	cfg, err := loadConfig([]string{"snatch", "--config", path})
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, cfg) {
+		assert.Equal(t, 100, cfg.parse.BufferSize)
+	}
+}
+
+func TestLoadConfigRejectsResolutionRulesOfRollups(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want string
+	}{
+		{name: "equal", rule: "api.*=1m", want: `invalid resolution rule "api.*=1m": must not equal rollup 1m0s`},
+		{name: "not dividing", rule: "api.*=7s", want: `invalid resolution rule "api.*=7s": must divide a rollup`},
+		{name: "coarser", rule: "api.*=1h", want: `invalid resolution rule "api.*=1h": must divide a rollup`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig([]string{"snatch", "--rollup", "1m", "--res-rule", tt.rule})
+
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestLoadConfigRejectsInvalidValues(t *testing.T) {
+	path := writeConfigFile(t, "late.policy: foo\n")
+
+	_, err := loadConfig([]string{"snatch", "--config", path})
+
+	assert.Error(t, err)
+}
+
+func TestFlagSource(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  bool
+		keys map[string]bool
+		want string
+	}{
+		{name: "default", want: "default"},
+		{name: "config file", keys: map[string]bool{"res": true}, want: "config file"},
+		{name: "env", env: true, keys: map[string]bool{"res": true}, want: "env SNATCH_RES"},
+		{name: "flag", args: []string{"--res=20s"}, env: true, keys: map[string]bool{"res": true}, want: "flag"},
+		{name: "flag with value", args: []string{"-res", "20s"}, want: "flag"},
+		{name: "other flag", args: []string{"--res-rule", "foo=1s"}, want: "default"},
+		{name: "after terminator", args: []string{"--", "--res=20s"}, want: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env {
+				t.Setenv("SNATCH_RES", "20s")
+			}
+
+			got := flagSource("res", append([]string{"snatch"}, tt.args...), tt.keys)
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{name: "none", yaml: "res: 20s\nparser.batch: 10\n"},
+		{name: "nested", yaml: "parser:\n  batch: 10\n  batches: 10\n", want: []string{"parser.batches"}},
+		{name: "sorted", yaml: "rse: 20s\ndb: http://localhost:8086/metrics\nbudget.polcy: drop\n", want: []string{"budget.polcy", "rse"}},
+		{name: "config is not a key", yaml: "config: foo.yaml\n", want: []string{"config"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := withContext(t, []string{"--config", writeConfigFile(t, tt.yaml)}, func(c *cli.Context) error {
+				var err error
+				got, err = unknownConfigKeys(c)
+				return err
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUnknownConfigKeysInvalidFile(t *testing.T) {
+	path := writeConfigFile(t, "res: [")
+
+	err := withContext(t, []string{"--config", path}, func(c *cli.Context) error {
+		_, err := unknownConfigKeys(c)
+		return err
+	})
+
+	assert.Error(t, err)
+}
+
+func TestConfigValidateDoesNotCreateDB(t *testing.T) {
+	for _, scheme := range []string{"file", "textfile"} {
+		t.Run(scheme, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics")
+			app := newApp()
+			app.Writer = ioutil.Discard
+
+			err := app.Run([]string{"snatch", "--config", writeConfigFile(t, ""), "--db", scheme + "://" + path, "config", "validate"})
+
+			assert.NoError(t, err)
+			_, err = os.Stat(path)
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
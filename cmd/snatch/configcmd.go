@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nrwiersma/snatch"
+	"gopkg.in/urfave/cli.v2"
+	"gopkg.in/urfave/cli.v2/altsrc"
+	"gopkg.in/yaml.v2"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Inspect the configuration",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "validate",
+			Usage:  "Validate the configuration, reporting unknown keys and invalid values",
+			Action: runConfigValidate,
+		},
+		{
+			Name:   "print",
+			Usage:  "Print the effective configuration and the source of each value",
+			Action: runConfigPrint,
+		},
+	},
+}
+
+func runConfigValidate(c *cli.Context) error {
+	var errs []string
+
+	unknown, err := unknownConfigKeys(c)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, key := range unknown {
+		errs = append(errs, fmt.Sprintf("unknown key %q", key))
+	}
+
+	var opts []snatch.Option
+	if cfg, err := newConfig(c); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		opts = cfg.opts
+	}
+
+	// The DB is not created, as creating it can write to its files
+	// or database.
+	if _, err := parseDB(c.String(flagDbDsn), opts...); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return errors.New("invalid configuration")
+	}
+
+	fmt.Fprintln(os.Stdout, "configuration is valid")
+	return nil
+}
+
+func runConfigPrint(c *cli.Context) error {
+	keys, err := readConfigKeys(c)
+	if err != nil {
+		return err
+	}
+
+	return printConfig(os.Stdout, c, os.Args, keys)
+}
+
+// printConfig writes the value and source of each flag.
+func printConfig(w io.Writer, c *cli.Context, args []string, keys map[string]bool) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+
+	for _, f := range newFlags() {
+		name := f.Names()[0]
+		if name == flagConfig {
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, flagValue(c, f), flagSource(name, args, keys))
+	}
+
+	return tw.Flush()
+}
+
+// flagValue returns the formatted value of the flag, masking passwords.
+func flagValue(c *cli.Context, f cli.Flag) string {
+	name := f.Names()[0]
+
+	switch f.(type) {
+	case *altsrc.BoolFlag:
+		return strconv.FormatBool(c.Bool(name))
+	case *altsrc.IntFlag:
+		return strconv.Itoa(c.Int(name))
+	case *altsrc.Int64Flag:
+		return strconv.FormatInt(c.Int64(name), 10)
+	case *altsrc.DurationFlag:
+		return c.Duration(name).String()
+	case *altsrc.StringSliceFlag:
+		return strings.Join(c.StringSlice(name), ",")
+	}
+
+	v := c.String(name)
+	if uri, err := url.Parse(v); err == nil && uri.User != nil {
+		if _, ok := uri.User.Password(); ok {
+			uri.User = url.UserPassword(uri.User.Username(), "xxxxx")
+			return uri.String()
+		}
+	}
+
+	return v
+}
+
+// flagSource returns where the value of the flag came from, in order
+// of precedence.
+func flagSource(name string, args []string, keys map[string]bool) string {
+	for _, arg := range args[1:] {
+		if arg == "--" {
+			break
+		}
+
+		arg = strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if arg == name {
+			return "flag"
+		}
+	}
+
+	for _, env := range envVars(name) {
+		if _, ok := os.LookupEnv(env); ok {
+			return "env " + env
+		}
+	}
+
+	if keys[name] {
+		return "config file"
+	}
+
+	return "default"
+}
+
+// unknownConfigKeys returns the keys of the config file that are not flags.
+func unknownConfigKeys(c *cli.Context) ([]string, error) {
+	keys, err := readConfigKeys(c)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, f := range newFlags() {
+		if _, ok := f.(altsrc.FlagInputSourceExtension); ok {
+			known[f.Names()[0]] = true
+		}
+	}
+
+	var unknown []string
+	for key := range keys {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown, nil
+}
+
+// readConfigKeys reads the keys set in the config file, with nested
+// keys joined by a dot.
+func readConfigKeys(c *cli.Context) (map[string]bool, error) {
+	path, err := expandPath(c.String(flagConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[interface{}]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+
+	keys := map[string]bool{}
+	flattenKeys(keys, "", m)
+
+	return keys, nil
+}
+
+func flattenKeys(keys map[string]bool, prefix string, m map[interface{}]interface{}) {
+	for k, v := range m {
+		key := prefix + fmt.Sprint(k)
+
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			flattenKeys(keys, key+".", nested)
+			continue
+		}
+
+		keys[key] = true
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/nrwiersma/snatch"
@@ -11,48 +14,112 @@ import (
 )
 
 func runReader(c *cli.Context) error {
-	res := c.Duration(flagResolution)
+	cfg, err := newConfig(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	db, err := newDB(cfg.db, cfg.opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	db, err := newDB(c.String(flagDbDsn))
+	store, err := newStore(cfg.store, cfg.res, cfg.opts...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	defer db.Close()
 
-	store := newStore(res)
+	app := newApplication(cfg.res, db, store, cfg.opts...)
+	r := &reloader{app: app, cfg: cfg, db: db, store: store}
+	defer r.close()
 
-	app := newApplication(res, db, store)
+	snapshot := cfg.snapshot
+	if snapshot != "" {
+		if _, ok := store.(snatch.Committer); ok {
+			fmt.Fprintln(os.Stderr, "snapshots cannot be used with a persistent store")
+			os.Exit(1)
+		}
 
-	scan := time.NewTicker(res)
-	defer scan.Stop()
-	go func() {
-		for range scan.C {
-			if err := app.Scan(); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
+		if err := restoreSnapshot(app, snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-	}()
+	}
+
+	if addr := c.String(flagAdmin); addr != "" {
+		go serveAdmin(addr, app, snatch.AdminOpts{Reload: r.reload})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel, c.Duration(flagShutdownTimeout))
+	go handleReload(r.reload)
+
+	opts := snatch.RunOpts{
+		ParseOpts:   cfg.parse,
+		InvalidLine: handleInvalidLine,
+		Final: func() error {
+			return shutdown(app, snapshot)
+		},
+	}
 
-	opts := snatch.ParseOpts{
-		BufferSize:     c.Int(flagParserBatch),
-		AllowedPending: c.Int(flagParserAllowPending),
+	if err := app.Run(ctx, os.Stdin, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	err = app.Parse(os.Stdin, opts, handleInvalidLine)
-	if err != nil && err != io.EOF {
+	return nil
+}
+
+// serveAdmin serves the admin HTTP server, exiting if it cannot listen.
+func serveAdmin(addr string, app *snatch.Application, opts snatch.AdminOpts) {
+	if err := http.ListenAndServe(addr, snatch.NewAdminHandler(app, opts)); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+}
+
+// handleSignals stops the application on SIGINT or SIGTERM, exiting
+// if the shutdown does not complete within the timeout.
+func handleSignals(stop context.CancelFunc, timeout time.Duration) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-sigs
+	fmt.Fprintf(os.Stderr, "snatch: received %s, shutting down\n", sig)
 
-	if err := app.Flush(); err != nil {
+	time.AfterFunc(timeout, func() {
+		fmt.Fprintln(os.Stderr, "snatch: shutdown timed out, buffered metrics were lost")
+		os.Exit(1)
+	})
+
+	// Stopping the application drains the parser queue.
+	stop()
+
+	sig = <-sigs
+	fmt.Fprintf(os.Stderr, "snatch: received %s, exiting\n", sig)
+	os.Exit(1)
+}
+
+// shutdown writes the remaining Buckets to the database, or to the
+// snapshot if one is configured.
+func shutdown(app *snatch.Application, snapshot string) error {
+	if snapshot != "" {
+		err := writeSnapshot(app, snapshot)
+		if err == nil {
+			return nil
+		}
+
+		// Fall back to flushing, rather than losing the data.
 		fmt.Fprintln(os.Stderr, err)
 	}
 
-	return nil
+	return app.Flush()
 }
 
 func handleInvalidLine(b []byte) {
-	fmt.Fprint(os.Stdout, string(b))
+	fmt.Fprint(os.Stderr, string(b))
 }
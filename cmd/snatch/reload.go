@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/nrwiersma/snatch"
+)
+
+// reloader reloads the configuration of a running application.
+type reloader struct {
+	mu    sync.Mutex
+	app   *snatch.Application
+	cfg   *config
+	db    snatch.DB
+	store snatch.Store
+}
+
+// reload re-reads the configuration, applying it if it is valid.
+//
+// The database and store are only replaced when their settings change,
+// after the complete Buckets are inserted into the current database
+// and the partial Buckets are moved into the new store.
+// New components are created before anything is replaced, so invalid
+// configuration is rejected without affecting the running application.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := loadConfig(os.Args)
+	if err != nil {
+		return err
+	}
+	if cfg.snapshot != r.cfg.snapshot {
+		return errors.New("snapshot cannot be changed without a restart")
+	}
+	if cfg.snapshot != "" && cfg.store != "" && cfg.store != "memory" {
+		return errors.New("snapshots cannot be used with a persistent store")
+	}
+
+	var store snatch.Store
+	if cfg.storeKey != r.cfg.storeKey {
+		// A persistent store would replay the log still held by the
+		// current store, and it keeps its Buckets across a restart.
+		if kind, _, _ := parseStore(cfg.store); kind != "memory" && cfg.store == r.cfg.store {
+			return errors.New("the settings of a persistent store cannot be changed without a restart")
+		}
+
+		store, err = newStore(cfg.store, cfg.res, cfg.opts...)
+		if err != nil {
+			return err
+		}
+	}
+
+	var db snatch.DB
+	if cfg.dbKey != r.cfg.dbKey {
+		db, err = newDB(cfg.db, cfg.opts...)
+		if err == nil {
+			err = ping(db)
+		}
+		if err != nil {
+			closeStore(store)
+			return err
+		}
+	}
+
+	err = r.app.Reload(snatch.Components{Res: cfg.res, DB: db, Store: store, Opts: cfg.opts})
+	if err != nil {
+		if db != nil {
+			db.Close()
+		}
+		closeStore(store)
+		return err
+	}
+
+	if db != nil {
+		r.db.Close()
+		r.db = db
+	}
+	if store != nil {
+		closeStore(r.store)
+		r.store = store
+	}
+
+	r.app.SetBufferSize(cfg.parse.BufferSize)
+	if cfg.parse.AllowedPending != r.cfg.parse.AllowedPending {
+		fmt.Fprintln(os.Stderr, "snatch: parser.allow-pending is applied on restart")
+	}
+	r.cfg = cfg
+
+	return nil
+}
+
+// ping checks that the database is reachable, if it can be checked.
+func ping(db snatch.DB) error {
+	p, ok := db.(snatch.Pinger)
+	if !ok {
+		return nil
+	}
+
+	if err := p.Ping(); err != nil {
+		db.Close()
+		return fmt.Errorf("db unreachable: %s", err)
+	}
+
+	return nil
+}
+
+// close closes the current database and store.
+func (r *reloader) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	closeStore(r.store)
+	r.db.Close()
+}
+
+func closeStore(s snatch.Store) {
+	if closer, ok := s.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// handleReload reloads the configuration on SIGHUP.
+func handleReload(reload func() error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	for range sigs {
+		if err := reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "snatch: reload failed: %s\n", err)
+			continue
+		}
+
+		fmt.Fprintln(os.Stderr, "snatch: reloaded configuration")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nrwiersma/snatch"
+	"gopkg.in/urfave/cli.v2"
+)
+
+const flagReplayProgress = "progress"
+
+var replayCommand = &cli.Command{
+	Name:      "replay",
+	Usage:     "Backfill metrics from historical logs, using the time of each line",
+	ArgsUsage: "[file...]",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  flagReplayProgress,
+			Value: time.Second,
+			Usage: "How often progress is reported (0 disables)",
+		},
+	},
+	Action: runReplay,
+}
+
+func runReplay(c *cli.Context) error {
+	cfg, err := newConfig(c)
+	if err != nil {
+		return err
+	}
+
+	paths := c.Args().Slice()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	// Time is driven by the lines, rather than the wall clock.
+	clock := snatch.NewFakeClock(time.Time{})
+	opts := append(cfg.opts, snatch.WithEventTime(), snatch.WithClock(clock))
+
+	db, err := newDB(cfg.db, opts...)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Replays can be rerun, so a persistent store is not needed.
+	app := newApplication(cfg.res, db, snatch.NewStore(cfg.res, opts...), opts...)
+
+	rep := &replayReporter{every: c.Duration(flagReplayProgress), start: time.Now()}
+	for _, path := range paths {
+		rep.base = rep.cur
+		if err := replayFile(app, path, rep); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	// The intervals can span files, so they are only flushed at the end.
+	if err := app.Flush(); err != nil {
+		return err
+	}
+	rep.done()
+
+	if rep.cur.Late > 0 {
+		fmt.Fprintf(os.Stderr, "replay: dropped %d late buckets, the files should be given in time order\n", rep.cur.Late)
+	}
+
+	return nil
+}
+
+// replayFile replays a possibly compressed file, or stdin for "-".
+func replayFile(app *snatch.Application, path string, rep *replayReporter) error {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return app.Replay(r, snatch.ReplayOpts{
+		InvalidLine: handleInvalidLine,
+		Progress:    rep.progress,
+	})
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress detects gzip and zstd compressed input, returning
+// a Reader of the decompressed lines.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// replayReporter reports the progress of a replay to stderr.
+type replayReporter struct {
+	every time.Duration
+	start time.Time
+	last  time.Time
+
+	// base is the progress of the previous files.
+	base snatch.ReplayProgress
+	cur  snatch.ReplayProgress
+}
+
+func (r *replayReporter) progress(p snatch.ReplayProgress) {
+	r.cur = snatch.ReplayProgress{
+		Lines:    r.base.Lines + p.Lines,
+		Rejected: r.base.Rejected + p.Rejected,
+		Late:     r.base.Late + p.Late,
+		Time:     p.Time,
+	}
+
+	if r.every <= 0 || time.Since(r.last) < r.every {
+		return
+	}
+	r.last = time.Now()
+	r.report()
+}
+
+func (r *replayReporter) done() {
+	if r.every > 0 {
+		r.report()
+	}
+}
+
+func (r *replayReporter) report() {
+	elapsed := time.Since(r.start)
+	rate := float64(r.cur.Lines) / elapsed.Seconds()
+
+	fmt.Fprintf(os.Stderr, "replay: %d lines (%d rejected, %d late buckets) up to %s, %.0f lines/s\n",
+		r.cur.Lines, r.cur.Rejected, r.cur.Late, r.cur.Time.Format(time.RFC3339), rate)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+
+	"github.com/nrwiersma/snatch"
+)
+
+// restoreSnapshot restores the snapshot at the path, if there is one,
+// removing it once restored.
+func restoreSnapshot(app *snatch.Application, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := app.Restore(f); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// writeSnapshot atomically writes a snapshot to the path.
+func writeSnapshot(app *snatch.Application, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := app.Snapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
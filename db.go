@@ -2,9 +2,9 @@ package snatch
 
 import (
 	"strings"
+	"time"
 
 	"github.com/influxdata/influxdb/client/v2"
-	"github.com/nrwiersma/snatch/utils"
 )
 
 // DB represents
@@ -15,30 +15,53 @@ type DB interface {
 	Close() error
 }
 
+// Pinger is implemented by DBs that can check they are reachable.
+type Pinger interface {
+	// Ping checks that the database is reachable.
+	Ping() error
+}
+
 type influxDB struct {
 	c        client.Client
 	database string
+	rollups  map[time.Duration]Rollup
+	agg      SampleAgg
 }
 
 // NewDB creates a new InfluxDB instance.
-func NewDB(c client.Client, database string) DB {
+func NewDB(c client.Client, database string, opts ...Option) DB {
+	o := newOptions(opts)
+
 	return &influxDB{
 		c:        c,
 		database: database,
+		rollups:  rollupsByRes(o.rollups),
+		agg:      o.sampleAgg,
 	}
 }
 
 // Insert inserts the Buckets into InfluxDB.
 func (db *influxDB) Insert(bkts []*Bucket) error {
-	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  db.database,
-		Precision: "s",
-	})
+	bps := map[string]client.BatchPoints{}
+	order := []string{}
 
 	for _, bkt := range bkts {
+		r := rollupOf(db.rollups, bkt)
+
+		bp, ok := bps[r.RetentionPolicy]
+		if !ok {
+			bp, _ = client.NewBatchPoints(client.BatchPointsConfig{
+				Database:        db.database,
+				RetentionPolicy: r.RetentionPolicy,
+				Precision:       "s",
+			})
+			bps[r.RetentionPolicy] = bp
+			order = append(order, r.RetentionPolicy)
+		}
+
 		p, _ := client.NewPoint(
-			db.formatName(bkt.ID.Name),
-			db.formatTags(bkt.ID.Tags),
+			pointName(bkt, r),
+			tagMap(bkt.ID.Tags),
 			db.formatValues(bkt),
 			bkt.ID.Time,
 		)
@@ -46,48 +69,74 @@ func (db *influxDB) Insert(bkts []*Bucket) error {
 		bp.AddPoint(p)
 	}
 
-	return db.c.Write(bp)
+	if len(order) == 0 {
+		bp, _ := client.NewBatchPoints(client.BatchPointsConfig{
+			Database:  db.database,
+			Precision: "s",
+		})
+		return db.c.Write(bp)
+	}
+
+	for _, rp := range order {
+		if err := db.c.Write(bps[rp]); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (db *influxDB) formatName(name string) string {
-	return strings.Replace(name, ".", "_", -1)
+func (db *influxDB) formatValues(b *Bucket) map[string]interface{} {
+	return b.Fields(db.agg)
 }
 
-func (db *influxDB) formatTags(tags []string) map[string]string {
-	m := make(map[string]string, len(tags)/2)
-	for i := 0; i < len(tags); i += 2 {
-		m[tags[i]] = tags[i+1]
+// Ping checks that InfluxDB is reachable.
+func (db *influxDB) Ping() error {
+	_, _, err := db.c.Ping(5 * time.Second)
+	return err
+}
+
+// Close closes the database.
+func (db *influxDB) Close() error {
+	return db.c.Close()
+}
+
+// rollupsByRes indexes the rollups by their resolution.
+func rollupsByRes(rollups []Rollup) map[time.Duration]Rollup {
+	m := make(map[time.Duration]Rollup, len(rollups))
+	for _, r := range rollups {
+		m[r.Res] = r
 	}
 
 	return m
 }
 
-func (db *influxDB) formatValues(b *Bucket) map[string]interface{} {
-	v := map[string]interface{}{}
-
-	switch b.ID.Type {
-	case Count:
-		v["value"] = int64(b.Sum)
-
-	case Sample:
-		v["value"] = b.Vals[len(b.Vals)-1]
-
-	case Measure:
-		v["90_percentile"] = utils.Percentile(b.Vals, 90)
-		v["95_percentile"] = utils.Percentile(b.Vals, 95)
-		v["97_percentile"] = utils.Percentile(b.Vals, 97)
-		v["99_percentile"] = utils.Percentile(b.Vals, 99)
-		v["count"] = len(b.Vals)
-		v["lower"] = utils.Min(b.Vals)
-		v["mean"] = b.Sum / float64(len(b.Vals))
-		v["sum"] = b.Sum
-		v["upper"] = utils.Max(b.Vals)
+// rollupOf returns the Rollup of a rolled up Bucket, or the zero
+// Rollup for Buckets at their own resolution.
+func rollupOf(rollups map[time.Duration]Rollup, bkt *Bucket) Rollup {
+	if !bkt.ID.Rollup {
+		return Rollup{}
 	}
 
-	return v
+	return rollups[bkt.ID.Res]
 }
 
-// Close closes the database.
-func (db *influxDB) Close() error {
-	return db.c.Close()
+// pointName returns the name a Bucket is written as.
+func pointName(bkt *Bucket, r Rollup) string {
+	name := strings.Replace(bkt.ID.Name, ".", "_", -1) + r.Suffix
+	if bkt.ID.Late {
+		name += "_late"
+	}
+
+	return name
+}
+
+// tagMap returns the tags of a Bucket as a map.
+func tagMap(tags []string) map[string]string {
+	m := make(map[string]string, len(tags)/2)
+	for i := 0; i+1 < len(tags); i += 2 {
+		m[tags[i]] = tags[i+1]
+	}
+
+	return m
 }
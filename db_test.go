@@ -99,6 +99,138 @@ func TestInfluxDB_Insert(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestInfluxDB_InsertRollups(t *testing.T) {
+	bkts := []*snatch.Bucket{
+		{
+			ID: &snatch.ID{
+				Time: time.Now().Truncate(time.Minute),
+				Name: "foo.bar",
+				Type: snatch.Count,
+				Res:  10 * time.Second,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID: &snatch.ID{
+				Time:   time.Now().Truncate(time.Minute),
+				Name:   "foo.bar",
+				Type:   snatch.Count,
+				Res:    time.Minute,
+				Rollup: true,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID: &snatch.ID{
+				Time:   time.Now().Truncate(time.Hour),
+				Name:   "foo.bar",
+				Type:   snatch.Count,
+				Res:    time.Hour,
+				Rollup: true,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID: &snatch.ID{
+				Time: time.Now().Truncate(time.Hour),
+				Name: "foo.baz",
+				Type: snatch.Count,
+				Res:  time.Hour,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+	}
+
+	var bps []client.BatchPoints
+	c := new(mockClient)
+	c.On("Write", mock.Anything).Run(func(args mock.Arguments) {
+		bps = append(bps, args.Get(0).(client.BatchPoints))
+	}).Return(nil)
+	db := snatch.NewDB(c, "testdb", snatch.WithRollups(
+		snatch.Rollup{Res: time.Minute, Suffix: "_1m"},
+		snatch.Rollup{Res: time.Hour, RetentionPolicy: "rp_1h"},
+	))
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	if assert.Len(t, bps, 2) {
+		assert.Equal(t, "", bps[0].RetentionPolicy())
+		assert.Len(t, bps[0].Points(), 3)
+		assert.Equal(t, "foo_bar", bps[0].Points()[0].Name())
+		assert.Equal(t, "foo_bar_1m", bps[0].Points()[1].Name())
+		assert.Equal(t, "foo_baz", bps[0].Points()[2].Name())
+		assert.Equal(t, "rp_1h", bps[1].RetentionPolicy())
+		assert.Equal(t, "foo_bar", bps[1].Points()[0].Name())
+	}
+}
+
+func TestInfluxDB_InsertLate(t *testing.T) {
+	bkts := []*snatch.Bucket{
+		{
+			ID: &snatch.ID{
+				Time: time.Now().Truncate(time.Minute),
+				Name: "foo.bar",
+				Type: snatch.Count,
+				Late: true,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+	}
+
+	c := new(mockClient)
+	c.On("Write", mock.Anything).Run(func(args mock.Arguments) {
+		bp := args.Get(0).(client.BatchPoints)
+
+		assert.Equal(t, "foo_bar_late", bp.Points()[0].Name())
+	}).Return(nil)
+	db := snatch.NewDB(c, "testdb")
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
+func TestInfluxDB_InsertSampleAggregation(t *testing.T) {
+	bkts := []*snatch.Bucket{
+		{
+			ID: &snatch.ID{
+				Time: time.Now().Truncate(time.Minute),
+				Name: "foo",
+				Type: snatch.Sample,
+			},
+			Vals: []float64{3, 1, 4, 2},
+			Sum:  10,
+		},
+	}
+
+	c := new(mockClient)
+	c.On("Write", mock.Anything).Run(func(args mock.Arguments) {
+		bp := args.Get(0).(client.BatchPoints)
+		fields, _ := bp.Points()[0].Fields()
+
+		assert.Equal(t, map[string]interface{}{
+			"first": float64(3),
+			"last":  float64(2),
+			"min":   float64(1),
+			"max":   float64(4),
+			"mean":  float64(2.5),
+		}, fields)
+	}).Return(nil)
+	db := snatch.NewDB(c, "testdb", snatch.WithSampleAggregation(snatch.SampleAll))
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	c.AssertExpectations(t)
+}
+
 func TestInfluxDB_Close(t *testing.T) {
 	c := new(mockClient)
 	c.On("Close").Return(nil)
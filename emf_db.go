@@ -0,0 +1,261 @@
+package snatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EMF limits.
+const (
+	emfMaxMetrics    = 100
+	emfMaxDimensions = 30
+)
+
+// emfUnits maps units to CloudWatch units.
+var emfUnits = map[string]string{
+	"us": "Microseconds",
+	"µs": "Microseconds",
+	"ms": "Milliseconds",
+	"s":  "Seconds",
+	"b":  "Bytes",
+	"B":  "Bytes",
+	"kb": "Kilobytes",
+	"KB": "Kilobytes",
+	"mb": "Megabytes",
+	"MB": "Megabytes",
+	"gb": "Gigabytes",
+	"GB": "Gigabytes",
+	"tb": "Terabytes",
+	"TB": "Terabytes",
+	"%":  "Percent",
+}
+
+// EMFOpts configures the writing of CloudWatch Embedded Metric Format documents.
+type EMFOpts struct {
+	// Namespace is the CloudWatch namespace, "snatch" by default.
+	Namespace string
+	// Dimensions are the tags used as dimensions. If empty, all tags are
+	// used. Only the first 30 dimensions are used, other tags are written
+	// as properties.
+	Dimensions []string
+}
+
+type emfMetric struct {
+	Name              string `json:"Name"`
+	Unit              string `json:"Unit,omitempty"`
+	StorageResolution int    `json:"StorageResolution,omitempty"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// emfStatisticSet is the EMF value of a Measure.
+type emfStatisticSet struct {
+	Max   float64 `json:"Max"`
+	Min   float64 `json:"Min"`
+	Count int     `json:"Count"`
+	Sum   float64 `json:"Sum"`
+}
+
+// emfDocument is an EMF document being built.
+type emfDocument struct {
+	ts      time.Time
+	tags    []string
+	metrics []emfMetric
+	values  map[string]interface{}
+}
+
+type emfDB struct {
+	mu        sync.Mutex
+	w         io.Writer
+	namespace string
+	dims      map[string]bool
+
+	rollups map[time.Duration]Rollup
+	agg     SampleAgg
+}
+
+// NewEMFDB creates a DB that writes Buckets to the Writer as CloudWatch
+// Embedded Metric Format documents, one per line.
+//
+// Buckets with the same time and tags are written in the same document,
+// up to 100 metrics. Measure Buckets are written as statistic sets.
+// Metrics named like a tag are prefixed with "metric_". Merged Count
+// and Measure Buckets are not written, as CloudWatch adds the values of
+// a metric at the same time rather than replacing them.
+//
+// Closing the DB does not close the Writer.
+func NewEMFDB(w io.Writer, emf EMFOpts, opts ...Option) DB {
+	o := newOptions(opts)
+
+	if emf.Namespace == "" {
+		emf.Namespace = "snatch"
+	}
+
+	var dims map[string]bool
+	if len(emf.Dimensions) > 0 {
+		dims = make(map[string]bool, len(emf.Dimensions))
+		for _, d := range emf.Dimensions {
+			dims[d] = true
+		}
+	}
+
+	return &emfDB{
+		w:         w,
+		namespace: emf.Namespace,
+		dims:      dims,
+		rollups:   rollupsByRes(o.rollups),
+		agg:       o.sampleAgg,
+	}
+}
+
+// Insert writes the Buckets to the Writer.
+func (db *emfDB) Insert(bkts []*Bucket) error {
+	var docs []*emfDocument
+	open := map[string]*emfDocument{}
+	add := func(bkt *Bucket, name string, m emfMetric, v interface{}) {
+		key := bkt.ID.Time.String() + ":" + strings.Join(bkt.ID.Tags, ",")
+
+		// Metrics and tags share the top level keys of the document.
+		for emfReserved(bkt.ID.Tags, name) {
+			name = "metric_" + name
+		}
+
+		doc, ok := open[key]
+		if ok && (len(doc.metrics) >= emfMaxMetrics || doc.values[name] != nil) {
+			ok = false
+		}
+		if !ok {
+			doc = &emfDocument{ts: bkt.ID.Time, tags: bkt.ID.Tags, values: map[string]interface{}{}}
+			open[key] = doc
+			docs = append(docs, doc)
+		}
+
+		m.Name = name
+		doc.metrics = append(doc.metrics, m)
+		doc.values[name] = v
+	}
+
+	for _, bkt := range sortBuckets(bkts) {
+		name := pointName(bkt, rollupOf(db.rollups, bkt))
+		m := emfMetric{Unit: emfUnits[bkt.Units]}
+		if bkt.ID.Res > 0 && bkt.ID.Res < time.Minute {
+			m.StorageResolution = 1
+		}
+
+		switch bkt.ID.Type {
+		case Count:
+			if bkt.Merged {
+				continue
+			}
+
+			if m.Unit == "" {
+				m.Unit = "Count"
+			}
+			add(bkt, name, m, bkt.Sum)
+
+		case Sample:
+			fields := bkt.Fields(db.agg)
+			for _, k := range sortedKeys(fields) {
+				n := name
+				if k != "value" {
+					n += "_" + k
+				}
+				add(bkt, n, m, fields[k])
+			}
+
+		case Measure:
+			if bkt.Merged || len(bkt.Vals) == 0 {
+				continue
+			}
+
+			fields := bkt.Fields(db.agg)
+			add(bkt, name, m, emfStatisticSet{
+				Max:   fields["upper"].(float64),
+				Min:   fields["lower"].(float64),
+				Count: len(bkt.Vals),
+				Sum:   bkt.Sum,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		b, err := json.Marshal(db.document(doc))
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.w.Write(buf.Bytes())
+	return err
+}
+
+// document returns the JSON object of a document.
+func (db *emfDB) document(doc *emfDocument) map[string]interface{} {
+	obj := make(map[string]interface{}, len(doc.values)+len(doc.tags)/2+1)
+
+	dims := []string{}
+	for i := 0; i+1 < len(doc.tags); i += 2 {
+		k := doc.tags[i]
+		obj[k] = doc.tags[i+1]
+
+		if (db.dims == nil || db.dims[k]) && len(dims) < emfMaxDimensions {
+			dims = append(dims, k)
+		}
+	}
+	sort.Strings(dims)
+
+	for k, v := range doc.values {
+		obj[k] = v
+	}
+
+	obj["_aws"] = emfMetadata{
+		Timestamp: doc.ts.UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []emfDirective{{
+			Namespace:  db.namespace,
+			Dimensions: [][]string{dims},
+			Metrics:    doc.metrics,
+		}},
+	}
+
+	return obj
+}
+
+// emfReserved determines if the name is used by the tags or metadata
+// of a document.
+func emfReserved(tags []string, name string) bool {
+	if name == "_aws" {
+		return true
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Close closes the database.
+func (db *emfDB) Close() error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package snatch_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEMFDB_Insert(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewEMFDB(&buf, snatch.EMFOpts{Namespace: "app"})
+	bkts := writerBuckets()
+	for _, bkt := range bkts {
+		bkt.ID.Res = 10 * time.Second
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1539166200000,
+			"CloudWatchMetrics": [{
+				"Namespace": "app",
+				"Dimensions": [["tag"]],
+				"Metrics": [
+					{"Name": "foo_counter", "Unit": "Count", "StorageResolution": 1},
+					{"Name": "foo_sample", "StorageResolution": 1}
+				]
+			}]
+		},
+		"tag": "example",
+		"foo_counter": 10,
+		"foo_sample": 2
+	}`, lines[0])
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1539166210000,
+			"CloudWatchMetrics": [{
+				"Namespace": "app",
+				"Dimensions": [["tag"]],
+				"Metrics": [{"Name": "foo_measure", "Unit": "Milliseconds", "StorageResolution": 1}]
+			}]
+		},
+		"tag": "a b",
+		"foo_measure": {"Max": 4, "Min": 1, "Count": 4, "Sum": 10}
+	}`, lines[1])
+}
+
+func TestEMFDB_InsertChoosesDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewEMFDB(&buf, snatch.EMFOpts{Dimensions: []string{"service"}})
+	bkt := &snatch.Bucket{
+		ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "test", Tags: []string{"service", "web", "request_id", "abc"}, Type: snatch.Count, Res: time.Minute},
+		Vals: []float64{1},
+		Sum:  1,
+	}
+
+	err := db.Insert([]*snatch.Bucket{bkt})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 60000,
+			"CloudWatchMetrics": [{"Namespace": "snatch", "Dimensions": [["service"]], "Metrics": [{"Name": "test", "Unit": "Count"}]}]
+		},
+		"service": "web",
+		"request_id": "abc",
+		"test": 1
+	}`, buf.String())
+}
+
+func TestEMFDB_InsertRespectsLimits(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewEMFDB(&buf, snatch.EMFOpts{})
+	var tags []string
+	for i := 0; i < 35; i++ {
+		tags = append(tags, "tag"+strconv.Itoa(i), "v")
+	}
+	var bkts []*snatch.Bucket
+	for i := 0; i < 150; i++ {
+		bkts = append(bkts, &snatch.Bucket{
+			ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "test" + strconv.Itoa(i), Tags: tags, Type: snatch.Count},
+			Vals: []float64{1},
+			Sum:  1,
+		})
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	var counts []int
+	for _, line := range lines {
+		var doc struct {
+			AWS struct {
+				CloudWatchMetrics []struct {
+					Dimensions [][]string
+					Metrics    []struct{ Name string }
+				}
+			} `json:"_aws"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(line), &doc))
+		d := doc.AWS.CloudWatchMetrics[0]
+		assert.Len(t, d.Dimensions[0], 30)
+		counts = append(counts, len(d.Metrics))
+	}
+	assert.Equal(t, []int{100, 50}, counts)
+}
+
+func TestEMFDB_InsertWritesFractionalCounts(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewEMFDB(&buf, snatch.EMFOpts{})
+	bkt := &snatch.Bucket{
+		ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "test", Type: snatch.Count, Res: time.Minute},
+		Vals: []float64{0.5, 1},
+		Sum:  1.5,
+	}
+
+	err := db.Insert([]*snatch.Bucket{bkt})
+
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"test":1.5`)
+}
+
+func TestEMFDB_InsertSkipsMergedBuckets(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewEMFDB(&buf, snatch.EMFOpts{})
+	first, merged := lateMergeScans(t)
+
+	err := db.Insert(first)
+	assert.NoError(t, err)
+	err = db.Insert(merged)
+
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], `"test":1`)
+	}
+}
+
+func TestEMFDB_InsertPrefixesMetricsNamedLikeTags(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewEMFDB(&buf, snatch.EMFOpts{})
+	bkts := []*snatch.Bucket{
+		{
+			ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "service", Tags: []string{"service", "web", "metric_service", "a"}, Type: snatch.Count, Res: time.Minute},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "_aws", Type: snatch.Count, Res: time.Minute},
+			Vals: []float64{2},
+			Sum:  2,
+		},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 60000,
+			"CloudWatchMetrics": [{"Namespace": "snatch", "Dimensions": [[]], "Metrics": [{"Name": "metric__aws", "Unit": "Count"}]}]
+		},
+		"metric__aws": 2
+	}`, lines[0])
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 60000,
+			"CloudWatchMetrics": [{"Namespace": "snatch", "Dimensions": [["metric_service", "service"]], "Metrics": [{"Name": "metric_metric_service", "Unit": "Count"}]}]
+		},
+		"service": "web",
+		"metric_service": "a",
+		"metric_metric_service": 1
+	}`, lines[1])
+}
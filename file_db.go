@@ -0,0 +1,243 @@
+package snatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOpts configures the writing and rotation of a file DB.
+type FileOpts struct {
+	// Format is the format of the file, FormatJSON by default.
+	Format Format
+	// MaxSize is the size in bytes after which the file is rotated.
+	MaxSize int64
+	// MaxAge is the age after which the file is rotated. The age of
+	// an existing file is counted from when it was last modified.
+	MaxAge time.Duration
+	// Gzip compresses the rotated files.
+	Gzip bool
+}
+
+type fileDB struct {
+	mu     sync.Mutex
+	path   string
+	opts   FileOpts
+	f      *os.File
+	size   int64
+	opened time.Time
+	wg     sync.WaitGroup
+
+	rollups map[time.Duration]Rollup
+	agg     SampleAgg
+	clock   Clock
+}
+
+// NewFileDB creates a DB that appends Buckets to the file at path.
+//
+// Once the file reaches its maximum size or age it is renamed with
+// the time of the rotation, optionally compressed, and a new file is
+// started. A rotation never splits the Buckets of an Insert. Rotated
+// files are compressed in the background, and a failure to compress
+// the rotated file does not fail the Insert.
+func NewFileDB(path string, fopts FileOpts, opts ...Option) (DB, error) {
+	o := newOptions(opts)
+
+	if fopts.Format == "" {
+		fopts.Format = FormatJSON
+	}
+
+	db := &fileDB{
+		path:    path,
+		opts:    fopts,
+		rollups: rollupsByRes(o.rollups),
+		agg:     o.sampleAgg,
+		clock:   o.clock,
+	}
+
+	if err := db.open(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Insert appends the Buckets to the file, rotating it if required.
+func (db *fileDB) Insert(bkts []*Bucket) error {
+	var buf bytes.Buffer
+	if err := encodeBuckets(&buf, sortBuckets(bkts), db.opts.Format, db.rollups, db.agg); err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.f == nil {
+		if err := db.open(); err != nil {
+			return err
+		}
+	}
+
+	if db.shouldRotate(int64(buf.Len())) {
+		if err := db.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if db.opts.Format == FormatCSV && db.size == 0 {
+		if err := db.write([]byte(csvHeader)); err != nil {
+			return err
+		}
+	}
+
+	return db.write(buf.Bytes())
+}
+
+func (db *fileDB) write(b []byte) error {
+	n, err := db.f.Write(b)
+	db.size += int64(n)
+	return err
+}
+
+// shouldRotate determines if the file must be rotated before
+// writing n bytes. Empty files are never rotated.
+func (db *fileDB) shouldRotate(n int64) bool {
+	if db.size == 0 {
+		return false
+	}
+
+	if db.opts.MaxSize > 0 && db.size+n > db.opts.MaxSize {
+		return true
+	}
+
+	return db.opts.MaxAge > 0 && db.clock.Now().Sub(db.opened) >= db.opts.MaxAge
+}
+
+// open opens the file for appending.
+func (db *fileDB) open() error {
+	f, err := os.OpenFile(db.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	db.f = f
+	db.size = fi.Size()
+	db.opened = db.clock.Now()
+	if db.size > 0 {
+		db.opened = fi.ModTime()
+	}
+
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. The rotated
+// file is compressed in the background, and kept uncompressed if it
+// cannot be compressed.
+func (db *fileDB) rotate() error {
+	if err := db.f.Close(); err != nil {
+		return err
+	}
+	db.f = nil
+
+	name := db.rotatedName()
+	if err := os.Rename(db.path, name); err != nil {
+		return err
+	}
+
+	if err := db.open(); err != nil {
+		return err
+	}
+
+	if db.opts.Gzip {
+		db.wg.Add(1)
+		go func() {
+			defer db.wg.Done()
+
+			if err := compressFile(name); err != nil {
+				fmt.Fprintf(os.Stderr, "snatch: could not compress %s: %v\n", name, err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// rotatedName returns an unused name for a rotated file, in the
+// form "name-20060102T150405Z.ext".
+func (db *fileDB) rotatedName() string {
+	ext := filepath.Ext(db.path)
+	base := strings.TrimSuffix(db.path, ext) + "-" + db.clock.Now().UTC().Format("20060102T150405Z")
+
+	name := base + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = base + "-" + strconv.Itoa(i) + ext
+	}
+
+	return name
+}
+
+// Close closes the file, waiting for rotated files to be compressed.
+func (db *fileDB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.wg.Wait()
+
+	if db.f == nil {
+		return nil
+	}
+
+	err := db.f.Close()
+	db.f = nil
+	return err
+}
+
+// compressFile gzips the file at path, removing the original.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// exists determines if a file exists.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,180 @@
+package snatch_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFileDB_InvalidPath(t *testing.T) {
+	_, err := snatch.NewFileDB(filepath.Join(t.TempDir(), "foo", "metrics.jsonl"), snatch.FileOpts{})
+
+	assert.Error(t, err)
+}
+
+func TestFileDB_Insert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+	db, err := snatch.NewFileDB(path, snatch.FileOpts{})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.Insert(writerBuckets()[2:])
+
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, `{"name":"foo_counter","type":"count","tags":{"tag":"example"},"time":"2018-10-10T10:10:00Z","fields":{"value":10}}
+`, string(b))
+}
+
+func TestFileDB_InsertAppendsCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.csv")
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{Format: snatch.FormatCSV})
+	_ = db.Insert(writerBuckets()[2:])
+	_ = db.Close()
+
+	db, err := snatch.NewFileDB(path, snatch.FileOpts{Format: snatch.FormatCSV})
+	assert.NoError(t, err)
+	err = db.Insert(writerBuckets()[2:])
+	assert.NoError(t, err)
+	_ = db.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, `time,name,type,tags,units,field,value
+2018-10-10T10:10:00Z,foo_counter,count,tag=example,,value,10
+2018-10-10T10:10:00Z,foo_counter,count,tag=example,,value,10
+`, string(b))
+}
+
+func TestFileDB_RotatesOnSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.csv")
+	clock := snatch.NewFakeClock(time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC))
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{Format: snatch.FormatCSV, MaxSize: 100}, snatch.WithClock(clock))
+	defer db.Close()
+
+	_ = db.Insert(writerBuckets()[2:])
+	err := db.Insert(writerBuckets()[2:])
+
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "metrics-20181010T101000Z.csv"))
+	assert.Equal(t, `time,name,type,tags,units,field,value
+2018-10-10T10:10:00Z,foo_counter,count,tag=example,,value,10
+`, string(b))
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, `time,name,type,tags,units,field,value
+2018-10-10T10:10:00Z,foo_counter,count,tag=example,,value,10
+`, string(b))
+}
+
+func TestFileDB_RotatesOnAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.jsonl")
+	clock := snatch.NewFakeClock(time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC))
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{MaxAge: time.Hour}, snatch.WithClock(clock))
+	defer db.Close()
+
+	_ = db.Insert(writerBuckets()[2:])
+	clock.Add(30 * time.Minute)
+	_ = db.Insert(writerBuckets()[2:])
+	clock.Add(30 * time.Minute)
+	_ = db.Insert(writerBuckets()[2:])
+	_ = db.Insert(writerBuckets()[2:])
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{filepath.Join(dir, "metrics-20181010T111000Z.jsonl"), path}, files)
+	assertLines(t, 2, files[0])
+	assertLines(t, 2, path)
+}
+
+func TestFileDB_RotatesOnAgeOfExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.jsonl")
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	_ = ioutil.WriteFile(path, []byte("{}\n"), 0644)
+	_ = os.Chtimes(path, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{MaxAge: time.Hour}, snatch.WithClock(snatch.NewFakeClock(now)))
+	defer db.Close()
+
+	_ = db.Insert(writerBuckets()[2:])
+
+	assertLines(t, 1, filepath.Join(dir, "metrics-20181010T101000Z.jsonl"))
+	assertLines(t, 1, path)
+}
+
+func TestFileDB_RotateUniqueNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.jsonl")
+	clock := snatch.NewFakeClock(time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC))
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{MaxSize: 1}, snatch.WithClock(clock))
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		_ = db.Insert(writerBuckets()[2:])
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "metrics-*"))
+	assert.Equal(t, []string{
+		filepath.Join(dir, "metrics-20181010T101000Z-1.jsonl"),
+		filepath.Join(dir, "metrics-20181010T101000Z.jsonl"),
+	}, files)
+}
+
+func TestFileDB_RotateGzip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.jsonl")
+	clock := snatch.NewFakeClock(time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC))
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{MaxSize: 1, Gzip: true}, snatch.WithClock(clock))
+
+	_ = db.Insert(writerBuckets()[2:])
+	err := db.Insert(writerBuckets()[2:])
+	_ = db.Close()
+
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "metrics-20181010T101000Z.jsonl"))
+	assert.True(t, os.IsNotExist(err))
+
+	f, err := os.Open(filepath.Join(dir, "metrics-20181010T101000Z.jsonl.gz"))
+	assert.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(gz)
+	assert.Equal(t, `{"name":"foo_counter","type":"count","tags":{"tag":"example"},"time":"2018-10-10T10:10:00Z","fields":{"value":10}}
+`, string(b))
+}
+
+func TestFileDB_RotateGzipFailureKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.jsonl")
+	clock := snatch.NewFakeClock(time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC))
+	db, _ := snatch.NewFileDB(path, snatch.FileOpts{MaxSize: 1, Gzip: true}, snatch.WithClock(clock))
+	// A dangling link cannot be created, failing the compression.
+	_ = os.Symlink(filepath.Join(dir, "missing", "metrics.gz"), filepath.Join(dir, "metrics-20181010T101000Z.jsonl.gz"))
+
+	_ = db.Insert(writerBuckets()[2:])
+	err := db.Insert(writerBuckets()[2:])
+	_ = db.Close()
+
+	assert.NoError(t, err)
+	assertLines(t, 1, filepath.Join(dir, "metrics-20181010T101000Z.jsonl"))
+	assertLines(t, 1, path)
+}
+
+func assertLines(t *testing.T, want int, path string) {
+	t.Helper()
+
+	b, _ := ioutil.ReadFile(path)
+	got := 0
+	for _, c := range b {
+		if c == '\n' {
+			got++
+		}
+	}
+	assert.Equal(t, want, got, path)
+}
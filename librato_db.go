@@ -0,0 +1,328 @@
+package snatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LibratoOpts configures the posting of metrics to Librato.
+type LibratoOpts struct {
+	// URL is the metrics endpoint, "https://metrics-api.librato.com/v1/metrics"
+	// by default.
+	URL string
+	// User is the email address of the account.
+	User string
+	// Token is the API token of the account.
+	Token string
+	// Source is the source of metrics without a source tag.
+	Source string
+	// SourceTag is the tag used as the source, "source" by default.
+	// Other tags are not sent, as Librato metrics only have a source.
+	SourceTag string
+	// BatchSize is the maximum number of measurements per request,
+	// 300 by default.
+	BatchSize int
+	// Client is the HTTP client used, with a 10 second timeout by default.
+	Client *http.Client
+}
+
+// libratoMeasurement is a Librato gauge or counter measurement.
+type libratoMeasurement struct {
+	Name        string             `json:"name"`
+	Source      string             `json:"source,omitempty"`
+	MeasureTime int64              `json:"measure_time"`
+	Period      int64              `json:"period,omitempty"`
+	Value       *float64           `json:"value,omitempty"`
+	Count       *int64             `json:"count,omitempty"`
+	Sum         *float64           `json:"sum,omitempty"`
+	Min         *float64           `json:"min,omitempty"`
+	Max         *float64           `json:"max,omitempty"`
+	SumSquares  *float64           `json:"sum_squares,omitempty"`
+	Attributes  *libratoAttributes `json:"attributes,omitempty"`
+}
+
+type libratoAttributes struct {
+	DisplayUnitsShort string `json:"display_units_short"`
+}
+
+// libratoGauge is a gauge measurement being built from the statistics
+// of one or more Buckets.
+type libratoGauge struct {
+	m     libratoMeasurement
+	stats libratoStats
+	// summary is set when the gauge is sent as a complex gauge
+	// with its statistics, rather than a single value.
+	summary bool
+}
+
+// libratoStats are the statistics of a complex gauge.
+type libratoStats struct {
+	count      int64
+	sum        float64
+	min        float64
+	max        float64
+	sumSquares float64
+}
+
+// merge merges the statistics into the current statistics.
+func (s *libratoStats) merge(o libratoStats) {
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.sumSquares += o.sumSquares
+}
+
+// libratoRequest is the body of a Librato request.
+type libratoRequest struct {
+	Gauges   []libratoMeasurement `json:"gauges,omitempty"`
+	Counters []libratoMeasurement `json:"counters,omitempty"`
+}
+
+type libratoDB struct {
+	mu   sync.Mutex
+	opts LibratoOpts
+	// counters is the running total of each counter.
+	counters map[string]float64
+
+	rollups map[time.Duration]Rollup
+	agg     SampleAgg
+}
+
+// NewLibratoDB creates a DB that posts Buckets to Librato.
+//
+// Count Buckets are posted as counters of their running total, Sample
+// Buckets as gauges, and Measure Buckets as complex gauges with their
+// count, sum, min, max and sum of squares.
+//
+// As tags other than the source are not sent, Buckets with the same name,
+// source and time are aggregated into one measurement: counts are summed
+// and gauges are posted as a complex gauge of their values. Merged Count
+// Buckets are not posted, as their interval was already added to the
+// running total.
+func NewLibratoDB(lopts LibratoOpts, opts ...Option) DB {
+	o := newOptions(opts)
+
+	if lopts.URL == "" {
+		lopts.URL = "https://metrics-api.librato.com/v1/metrics"
+	}
+	if lopts.SourceTag == "" {
+		lopts.SourceTag = "source"
+	}
+	if lopts.BatchSize <= 0 {
+		lopts.BatchSize = 300
+	}
+	if lopts.Client == nil {
+		lopts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &libratoDB{
+		opts:     lopts,
+		counters: map[string]float64{},
+		rollups:  rollupsByRes(o.rollups),
+		agg:      o.sampleAgg,
+	}
+}
+
+// Insert posts the Buckets to Librato, in batches.
+func (db *libratoDB) Insert(bkts []*Bucket) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var counters []libratoMeasurement
+	var gauges []*libratoGauge
+	byKey := map[string]int{}
+	for _, bkt := range sortBuckets(bkts) {
+		m := libratoMeasurement{
+			Name:        db.name(bkt),
+			Source:      db.source(bkt.ID.Tags),
+			MeasureTime: bkt.ID.Time.Unix(),
+			Period:      int64(bkt.ID.Res / time.Second),
+		}
+		if bkt.Units != "" {
+			m.Attributes = &libratoAttributes{DisplayUnitsShort: bkt.Units}
+		}
+
+		switch bkt.ID.Type {
+		case Count:
+			if bkt.Merged {
+				continue
+			}
+
+			total := m.Name + "|" + m.Source
+			db.counters[total] += bkt.Sum
+			m.Value = floatPtr(db.counters[total])
+
+			key := "c|" + total + "|" + strconv.FormatInt(m.MeasureTime, 10)
+			if i, ok := byKey[key]; ok {
+				counters[i].Value = m.Value
+				continue
+			}
+			byKey[key] = len(counters)
+			counters = append(counters, m)
+
+		case Sample:
+			fields := bkt.Fields(db.agg)
+			for _, k := range sortedKeys(fields) {
+				g := m
+				if k != "value" {
+					g.Name += "." + k
+				}
+				v := fields[k].(float64)
+				gauges = addLibratoGauge(gauges, byKey, g, libratoStats{count: 1, sum: v, min: v, max: v, sumSquares: v * v}, false)
+			}
+
+		case Measure:
+			if len(bkt.Vals) == 0 {
+				continue
+			}
+
+			fields := bkt.Fields(db.agg)
+			stats := libratoStats{
+				count: int64(len(bkt.Vals)),
+				sum:   bkt.Sum,
+				min:   fields["lower"].(float64),
+				max:   fields["upper"].(float64),
+			}
+			for _, v := range bkt.Vals {
+				stats.sumSquares += v * v
+			}
+			gauges = addLibratoGauge(gauges, byKey, m, stats, true)
+		}
+	}
+
+	measurements := make([]libratoMeasurement, 0, len(gauges))
+	for _, g := range gauges {
+		measurements = append(measurements, g.measurement())
+	}
+
+	return db.postBatches(measurements, counters)
+}
+
+// postBatches posts the measurements in batches.
+func (db *libratoDB) postBatches(gauges, counters []libratoMeasurement) error {
+	for len(gauges) > 0 || len(counters) > 0 {
+		var req libratoRequest
+		n := db.opts.BatchSize
+		req.Gauges, gauges = splitMeasurements(gauges, n)
+		req.Counters, counters = splitMeasurements(counters, n-len(req.Gauges))
+
+		if err := db.post(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addLibratoGauge adds a gauge of the statistics, merging it into the gauge
+// with the same name, source and time as a complex gauge.
+func addLibratoGauge(gauges []*libratoGauge, byKey map[string]int, m libratoMeasurement, stats libratoStats, summary bool) []*libratoGauge {
+	key := "g|" + m.Name + "|" + m.Source + "|" + strconv.FormatInt(m.MeasureTime, 10)
+	if i, ok := byKey[key]; ok {
+		gauges[i].stats.merge(stats)
+		gauges[i].summary = true
+		return gauges
+	}
+
+	byKey[key] = len(gauges)
+	return append(gauges, &libratoGauge{m: m, stats: stats, summary: summary})
+}
+
+// measurement returns the measurement of the gauge.
+func (g *libratoGauge) measurement() libratoMeasurement {
+	m := g.m
+	if !g.summary {
+		m.Value = floatPtr(g.stats.sum)
+		return m
+	}
+
+	count := g.stats.count
+	m.Count = &count
+	m.Sum = floatPtr(g.stats.sum)
+	m.Min = floatPtr(g.stats.min)
+	m.Max = floatPtr(g.stats.max)
+	m.SumSquares = floatPtr(g.stats.sumSquares)
+
+	return m
+}
+
+// name returns the name of a Bucket. Librato names keep their dots.
+func (db *libratoDB) name(bkt *Bucket) string {
+	name := bkt.ID.Name + rollupOf(db.rollups, bkt).Suffix
+	if bkt.ID.Late {
+		name += "_late"
+	}
+
+	return name
+}
+
+// source returns the source of the tags.
+func (db *libratoDB) source(tags []string) string {
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i] == db.opts.SourceTag {
+			return tags[i+1]
+		}
+	}
+
+	return db.opts.Source
+}
+
+// post posts a batch of measurements.
+func (db *libratoDB) post(lr libratoRequest) error {
+	b, err := json.Marshal(lr)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, db.opts.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(db.opts.User, db.opts.Token)
+
+	resp, err := db.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("snatch: librato post failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Close closes the database.
+func (db *libratoDB) Close() error {
+	db.opts.Client.CloseIdleConnections()
+	return nil
+}
+
+// splitMeasurements splits off at most n measurements.
+func splitMeasurements(ms []libratoMeasurement, n int) ([]libratoMeasurement, []libratoMeasurement) {
+	if n > len(ms) {
+		n = len(ms)
+	}
+
+	return ms[:n], ms[n:]
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,197 @@
+package snatch_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveLibrato(t *testing.T, status int) (*httptest.Server, chan string) {
+	t.Helper()
+
+	reqs := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, token, _ := r.BasicAuth()
+		assert.Equal(t, "user@example.com", user)
+		assert.Equal(t, "token", token)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		b, _ := ioutil.ReadAll(r.Body)
+		reqs <- string(b)
+
+		w.WriteHeader(status)
+		if status >= 300 {
+			_, _ = w.Write([]byte(`{"errors":{"request":["invalid"]}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, reqs
+}
+
+func newLibratoDB(srv *httptest.Server, batch int) snatch.DB {
+	return snatch.NewLibratoDB(snatch.LibratoOpts{
+		URL:       srv.URL,
+		User:      "user@example.com",
+		Token:     "token",
+		Source:    "host1",
+		SourceTag: "source",
+		BatchSize: batch,
+	})
+}
+
+// libratoBucket returns a Bucket of the values at the time in seconds.
+func libratoBucket(typ snatch.Type, sec int64, tags []string, vals ...float64) *snatch.Bucket {
+	bkt := &snatch.Bucket{
+		ID: &snatch.ID{Time: time.Unix(sec, 0), Name: "foo." + string(typ), Tags: tags, Type: typ, Res: 10 * time.Second},
+	}
+	for _, v := range vals {
+		bkt.Append(v)
+	}
+
+	return bkt
+}
+
+func TestLibratoDB_Insert(t *testing.T) {
+	srv, reqs := serveLibrato(t, http.StatusOK)
+	db := newLibratoDB(srv, 0)
+	defer db.Close()
+	measure := libratoBucket(snatch.Measure, 10, nil, 1, 2, 3, 4)
+	measure.Units = "ms"
+	bkts := []*snatch.Bucket{
+		libratoBucket(snatch.Count, 10, []string{"source", "web"}, 1, 2),
+		libratoBucket(snatch.Sample, 10, []string{"source", "web"}, 1, 2),
+		measure,
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"gauges": [
+			{"name":"foo.measure","source":"host1","measure_time":10,"period":10,"count":4,"sum":10,"min":1,"max":4,"sum_squares":30,"attributes":{"display_units_short":"ms"}},
+			{"name":"foo.sample","source":"web","measure_time":10,"period":10,"value":2}
+		],
+		"counters": [
+			{"name":"foo.count","source":"web","measure_time":10,"period":10,"value":3}
+		]
+	}`, <-reqs)
+}
+
+func TestLibratoDB_InsertPostsRunningTotals(t *testing.T) {
+	srv, reqs := serveLibrato(t, http.StatusOK)
+	db := newLibratoDB(srv, 0)
+
+	err := db.Insert([]*snatch.Bucket{
+		libratoBucket(snatch.Count, 10, []string{"source", "web"}, 3),
+		libratoBucket(snatch.Count, 10, []string{"source", "db"}, 1),
+	})
+	assert.NoError(t, err)
+	err = db.Insert([]*snatch.Bucket{libratoBucket(snatch.Count, 20, []string{"source", "web"}, 4)})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"counters": [
+			{"name":"foo.count","source":"db","measure_time":10,"period":10,"value":1},
+			{"name":"foo.count","source":"web","measure_time":10,"period":10,"value":3}
+		]
+	}`, <-reqs)
+	assert.JSONEq(t, `{"counters":[{"name":"foo.count","source":"web","measure_time":20,"period":10,"value":7}]}`, <-reqs)
+}
+
+func TestLibratoDB_InsertAggregatesTagCollisions(t *testing.T) {
+	srv, reqs := serveLibrato(t, http.StatusOK)
+	db := newLibratoDB(srv, 0)
+	bkts := []*snatch.Bucket{
+		libratoBucket(snatch.Count, 10, []string{"source", "web", "path", "/a"}, 1),
+		libratoBucket(snatch.Count, 10, []string{"source", "web", "path", "/b"}, 2),
+		libratoBucket(snatch.Sample, 10, []string{"source", "web", "path", "/a"}, 1),
+		libratoBucket(snatch.Sample, 10, []string{"source", "web", "path", "/b"}, 3),
+		libratoBucket(snatch.Measure, 10, []string{"source", "web", "path", "/a"}, 1, 2),
+		libratoBucket(snatch.Measure, 10, []string{"source", "web", "path", "/b"}, 5),
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"gauges": [
+			{"name":"foo.measure","source":"web","measure_time":10,"period":10,"count":3,"sum":8,"min":1,"max":5,"sum_squares":30},
+			{"name":"foo.sample","source":"web","measure_time":10,"period":10,"count":2,"sum":4,"min":1,"max":3,"sum_squares":10}
+		],
+		"counters": [
+			{"name":"foo.count","source":"web","measure_time":10,"period":10,"value":3}
+		]
+	}`, <-reqs)
+}
+
+func TestLibratoDB_InsertSkipsMergedCounters(t *testing.T) {
+	srv, reqs := serveLibrato(t, http.StatusOK)
+	db := newLibratoDB(srv, 0)
+	first, merged := lateMergeScans(t)
+	next := libratoBucket(snatch.Count, first[0].ID.Time.Unix()+1, []string{"source", "web"}, 1)
+	next.ID.Name = "test"
+
+	err := db.Insert(first)
+	assert.NoError(t, err)
+	err = db.Insert(merged)
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 1)
+	err = db.Insert([]*snatch.Bucket{next})
+
+	assert.NoError(t, err)
+	<-reqs
+	assert.Contains(t, <-reqs, `"value":2`)
+	assert.Len(t, reqs, 0)
+}
+
+func TestLibratoDB_InsertBatches(t *testing.T) {
+	srv, reqs := serveLibrato(t, http.StatusOK)
+	db := newLibratoDB(srv, 2)
+	bkts := []*snatch.Bucket{
+		libratoBucket(snatch.Count, 10, nil, 1),
+		libratoBucket(snatch.Sample, 10, nil, 1),
+		libratoBucket(snatch.Sample, 20, nil, 2),
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 2)
+	assert.JSONEq(t, `{
+		"gauges": [
+			{"name":"foo.sample","source":"host1","measure_time":10,"period":10,"value":1},
+			{"name":"foo.sample","source":"host1","measure_time":20,"period":10,"value":2}
+		]
+	}`, <-reqs)
+	assert.JSONEq(t, `{"counters":[{"name":"foo.count","source":"host1","measure_time":10,"period":10,"value":1}]}`, <-reqs)
+}
+
+func TestLibratoDB_InsertUsesRollupNames(t *testing.T) {
+	srv, reqs := serveLibrato(t, http.StatusOK)
+	r, _ := snatch.ParseRollup("1m")
+	db := snatch.NewLibratoDB(snatch.LibratoOpts{URL: srv.URL, User: "user@example.com", Token: "token"}, snatch.WithRollups(r))
+	bkt := &snatch.Bucket{
+		ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "foo.bar", Type: snatch.Sample, Res: time.Minute, Rollup: true, Late: true},
+		Vals: []float64{0},
+	}
+
+	err := db.Insert([]*snatch.Bucket{bkt})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"gauges":[{"name":"foo.bar_1m0s_late","measure_time":60,"period":60,"value":0}]}`, <-reqs)
+}
+
+func TestLibratoDB_InsertHandlesErrors(t *testing.T) {
+	srv, _ := serveLibrato(t, http.StatusBadRequest)
+	db := newLibratoDB(srv, 0)
+
+	err := db.Insert([]*snatch.Bucket{libratoBucket(snatch.Count, 10, nil, 1)})
+
+	assert.EqualError(t, err, `snatch: librato post failed: 400 Bad Request: {"errors":{"request":["invalid"]}}`)
+}
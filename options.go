@@ -0,0 +1,232 @@
+package snatch
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// Option configures the snatch components.
+//
+// Options are shared between components, each component only
+// reads the settings that apply to it.
+type Option func(*options)
+
+type options struct {
+	rollups     []Rollup
+	rules       []ResolutionRule
+	eventTime   bool
+	lateness    time.Duration
+	latePolicy  LatePolicy
+	lateHorizon time.Duration
+	fillTTL     int
+	sampleAgg   SampleAgg
+	budget      Budget
+	selfMetrics bool
+	selfPrefix  string
+	clock       Clock
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		lateness:    time.Second,
+		latePolicy:  LateDrop,
+		lateHorizon: 10 * time.Minute,
+		sampleAgg:   SampleLast,
+		clock:       NewClock(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Rollup represents a coarser resolution derived from finer Buckets.
+type Rollup struct {
+	// Res is the resolution of the rollup.
+	Res time.Duration
+	// RetentionPolicy is the retention policy the rollup is written to.
+	RetentionPolicy string
+	// Suffix is appended to the name of the rollup metrics.
+	Suffix string
+}
+
+// ParseRollup parses a rollup in the form "res[:retention-policy]".
+//
+// If no retention policy is given, the rollup is written with the
+// resolution appended to the metric name.
+func ParseRollup(s string) (Rollup, error) {
+	parts := strings.SplitN(s, ":", 2)
+	res, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return Rollup{}, err
+	}
+	if res <= 0 {
+		return Rollup{}, errors.New("snatch: rollup resolution must be positive")
+	}
+
+	r := Rollup{Res: res}
+	if len(parts) == 2 && parts[1] != "" {
+		r.RetentionPolicy = parts[1]
+		return r, nil
+	}
+
+	r.Suffix = "_" + res.String()
+	return r, nil
+}
+
+// WithRollups configures the rollups to produce.
+func WithRollups(rollups ...Rollup) Option {
+	return func(o *options) {
+		o.rollups = append(o.rollups, rollups...)
+	}
+}
+
+// ResolutionRule overrides the resolution of metrics matching a name pattern.
+type ResolutionRule struct {
+	// Pattern is the metric name pattern, as understood by path.Match.
+	Pattern string
+	// Res is the resolution of the matching metrics.
+	Res time.Duration
+}
+
+// Match determines if the rule matches the metric name.
+func (r ResolutionRule) Match(name string) bool {
+	ok, _ := path.Match(r.Pattern, name)
+	return ok
+}
+
+// ParseResolutionRule parses a resolution rule in the form "pattern=res".
+func ParseResolutionRule(s string) (ResolutionRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return ResolutionRule{}, errors.New("snatch: resolution rule must be in the form pattern=res")
+	}
+
+	pattern := s[:i]
+	if _, err := path.Match(pattern, ""); err != nil {
+		return ResolutionRule{}, err
+	}
+
+	res, err := time.ParseDuration(s[i+1:])
+	if err != nil {
+		return ResolutionRule{}, err
+	}
+	if res <= 0 {
+		return ResolutionRule{}, errors.New("snatch: rule resolution must be positive")
+	}
+
+	return ResolutionRule{Pattern: pattern, Res: res}, nil
+}
+
+// WithResolutionRules configures per metric resolution overrides.
+//
+// The first matching rule determines the resolution of a metric.
+func WithResolutionRules(rules ...ResolutionRule) Option {
+	return func(o *options) {
+		o.rules = append(o.rules, rules...)
+	}
+}
+
+// WithEventTime configures the Parser to use the time of the line,
+// given by the "t" key, instead of the time it was parsed. Lines
+// without a time use the time they were parsed, lines with an invalid
+// time are rejected.
+func WithEventTime() Option {
+	return func(o *options) {
+		o.eventTime = true
+	}
+}
+
+// LatePolicy represents the handling of data that arrives after its
+// interval has been emitted.
+type LatePolicy string
+
+// LatePolicy constants.
+const (
+	// LateDrop drops late data.
+	LateDrop LatePolicy = "drop"
+	// LateMerge merges late data into the emitted Bucket, emitting
+	// the corrected Bucket on the next scan.
+	LateMerge LatePolicy = "merge"
+	// LateRoute emits late data in separate Buckets marked as late.
+	LateRoute LatePolicy = "route"
+)
+
+// ParseLatePolicy parses a late data policy.
+func ParseLatePolicy(s string) (LatePolicy, error) {
+	switch p := LatePolicy(s); p {
+	case LateDrop, LateMerge, LateRoute:
+		return p, nil
+	}
+
+	return "", errors.New("snatch: invalid late policy: " + s)
+}
+
+// WithLateness configures how long after the end of an interval
+// data is waited for before the interval is emitted.
+func WithLateness(d time.Duration) Option {
+	return func(o *options) {
+		o.lateness = d
+	}
+}
+
+// WithLatePolicy configures the handling of late data.
+//
+// The horizon is how long after an interval has been emitted late
+// data is merged into it, and is only used by LateMerge.
+func WithLatePolicy(p LatePolicy, horizon time.Duration) Option {
+	return func(o *options) {
+		o.latePolicy = p
+		o.lateHorizon = horizon
+	}
+}
+
+// WithGapFill configures the Store to fill intervals without data
+// for recently active series, for up to ttl intervals.
+//
+// Counts are filled with zero and samples with their last value.
+func WithGapFill(ttl int) Option {
+	return func(o *options) {
+		o.fillTTL = ttl
+	}
+}
+
+// SampleAgg represents the aggregation of Sample values in an interval.
+type SampleAgg string
+
+// SampleAgg constants.
+const (
+	SampleLast  SampleAgg = "last"
+	SampleFirst SampleAgg = "first"
+	SampleMin   SampleAgg = "min"
+	SampleMax   SampleAgg = "max"
+	SampleMean  SampleAgg = "mean"
+	SampleAll   SampleAgg = "all"
+)
+
+// ParseSampleAgg parses a sample aggregation.
+func ParseSampleAgg(s string) (SampleAgg, error) {
+	switch a := SampleAgg(s); a {
+	case SampleLast, SampleFirst, SampleMin, SampleMax, SampleMean, SampleAll:
+		return a, nil
+	}
+
+	return "", errors.New("snatch: invalid sample aggregation: " + s)
+}
+
+// WithSampleAggregation configures the aggregation of Sample values.
+func WithSampleAggregation(agg SampleAgg) Option {
+	return func(o *options) {
+		o.sampleAgg = agg
+	}
+}
+
+// WithClock configures the Clock used to tell the time.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
@@ -0,0 +1,99 @@
+package snatch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRollup(t *testing.T) {
+	tests := []struct {
+		in   string
+		want snatch.Rollup
+	}{
+		{
+			in:   "1m",
+			want: snatch.Rollup{Res: time.Minute, Suffix: "_1m0s"},
+		},
+		{
+			in:   "1h:rp_1h",
+			want: snatch.Rollup{Res: time.Hour, RetentionPolicy: "rp_1h"},
+		},
+	}
+
+	for _, tt := range tests {
+		r, err := snatch.ParseRollup(tt.in)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, r)
+	}
+}
+
+func TestParseRollupErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"foo",
+		"-1m",
+		"0s:rp",
+	}
+
+	for _, tt := range tests {
+		_, err := snatch.ParseRollup(tt)
+
+		assert.Error(t, err)
+	}
+}
+
+func TestParseResolutionRule(t *testing.T) {
+	r, err := snatch.ParseResolutionRule("incident.*=1s")
+
+	assert.NoError(t, err)
+	assert.Equal(t, snatch.ResolutionRule{Pattern: "incident.*", Res: time.Second}, r)
+	assert.True(t, r.Match("incident.errors"))
+	assert.False(t, r.Match("requests"))
+}
+
+func TestParseResolutionRuleErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"=1s",
+		"foo",
+		"foo=bar",
+		"foo=-1s",
+		"[=1s",
+	}
+
+	for _, tt := range tests {
+		_, err := snatch.ParseResolutionRule(tt)
+
+		assert.Error(t, err, tt)
+	}
+}
+
+func TestParseLatePolicy(t *testing.T) {
+	for _, s := range []string{"drop", "merge", "route"} {
+		p, err := snatch.ParseLatePolicy(s)
+
+		assert.NoError(t, err)
+		assert.Equal(t, snatch.LatePolicy(s), p)
+	}
+
+	_, err := snatch.ParseLatePolicy("foo")
+
+	assert.Error(t, err)
+}
+
+func TestParseSampleAgg(t *testing.T) {
+	for _, s := range []string{"last", "first", "min", "max", "mean", "all"} {
+		a, err := snatch.ParseSampleAgg(s)
+
+		assert.NoError(t, err)
+		assert.Equal(t, snatch.SampleAgg(s), a)
+	}
+
+	_, err := snatch.ParseSampleAgg("foo")
+
+	assert.Error(t, err)
+}
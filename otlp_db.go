@@ -0,0 +1,270 @@
+package snatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpScope is the instrumentation scope of the exported metrics.
+const otlpScope = "github.com/nrwiersma/snatch"
+
+// OTLPOpts configures the exporting of metrics over OTLP/HTTP.
+type OTLPOpts struct {
+	// Endpoint is the URL the metrics are posted to,
+	// e.g. "http://localhost:4318/v1/metrics".
+	Endpoint string
+	// Headers are added to each request.
+	Headers map[string]string
+	// Resource is the attributes of the resource producing the metrics.
+	// The "service.name" attribute defaults to "snatch".
+	Resource map[string]string
+	// Client is the HTTP client used, with a 10 second timeout by default.
+	Client *http.Client
+}
+
+type otlpDB struct {
+	endpoint string
+	headers  map[string]string
+	resource *resourcepb.Resource
+	client   *http.Client
+
+	rollups map[time.Duration]Rollup
+	agg     SampleAgg
+}
+
+// NewOTLPDB creates a DB that exports Buckets as OTLP metrics over
+// HTTP, encoded as protobuf.
+//
+// Count Buckets are exported as monotonic delta Sums, Sample Buckets as
+// Gauges and Measure Buckets as Summaries, with the lower and upper
+// values as the 0 and 1 quantiles. Tags are exported as attributes.
+//
+// Merged Buckets are not exported, as their interval was already
+// exported and the deltas cannot be corrected. Buckets without a
+// resolution are not exported, as their interval is unknown.
+func NewOTLPDB(otlp OTLPOpts, opts ...Option) DB {
+	o := newOptions(opts)
+
+	res := map[string]string{"service.name": "snatch"}
+	for k, v := range otlp.Resource {
+		res[k] = v
+	}
+
+	client := otlp.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &otlpDB{
+		endpoint: otlp.Endpoint,
+		headers:  otlp.Headers,
+		resource: &resourcepb.Resource{Attributes: otlpAttributes(mapPairs(res))},
+		client:   client,
+		rollups:  rollupsByRes(o.rollups),
+		agg:      o.sampleAgg,
+	}
+}
+
+// Insert exports the Buckets to the endpoint.
+func (db *otlpDB) Insert(bkts []*Bucket) error {
+	metrics := db.metrics(sortBuckets(bkts))
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	req := &collectormetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: db.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otlpScope},
+				Metrics: metrics,
+			}},
+		}},
+	}
+
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return db.export(b)
+}
+
+// metrics converts the Buckets to metrics, grouping the data points
+// of each name and type.
+func (db *otlpDB) metrics(bkts []*Bucket) []*metricspb.Metric {
+	var metrics []*metricspb.Metric
+	byKey := map[string]*metricspb.Metric{}
+	get := func(name string, typ Type, unit string) *metricspb.Metric {
+		key := string(typ) + ":" + name + ":" + unit
+		if m, ok := byKey[key]; ok {
+			return m
+		}
+
+		m := &metricspb.Metric{Name: name, Unit: unit}
+		switch typ {
+		case Count:
+			m.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				IsMonotonic:            true,
+			}}
+		case Sample:
+			m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+		case Measure:
+			m.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{}}
+		}
+
+		byKey[key] = m
+		metrics = append(metrics, m)
+		return m
+	}
+
+	for _, bkt := range bkts {
+		if bkt.Merged || bkt.ID.Res <= 0 {
+			continue
+		}
+
+		name := pointName(bkt, rollupOf(db.rollups, bkt))
+		attrs := otlpAttributes(bkt.ID.Tags)
+		start := uint64(bkt.ID.Time.UnixNano())
+		end := uint64(bkt.ID.Time.Add(bkt.ID.Res).UnixNano())
+
+		switch bkt.ID.Type {
+		case Count:
+			sum := get(name, Count, bkt.Units).GetSum()
+			sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(bkt.Sum)},
+			})
+
+		case Sample:
+			fields := bkt.Fields(db.agg)
+			for _, k := range sortedKeys(fields) {
+				n := name
+				if k != "value" {
+					n += "_" + k
+				}
+
+				gauge := get(n, Sample, bkt.Units).GetGauge()
+				gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: start,
+					TimeUnixNano:      end,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: fields[k].(float64)},
+				})
+			}
+
+		case Measure:
+			if len(bkt.Vals) == 0 {
+				continue
+			}
+
+			fields := bkt.Fields(db.agg)
+			summary := get(name, Measure, bkt.Units).GetSummary()
+			summary.DataPoints = append(summary.DataPoints, &metricspb.SummaryDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				Count:             uint64(len(bkt.Vals)),
+				Sum:               bkt.Sum,
+				QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{
+					{Quantile: 0, Value: fields["lower"].(float64)},
+					{Quantile: 0.9, Value: fields["90_percentile"].(float64)},
+					{Quantile: 0.95, Value: fields["95_percentile"].(float64)},
+					{Quantile: 0.97, Value: fields["97_percentile"].(float64)},
+					{Quantile: 0.99, Value: fields["99_percentile"].(float64)},
+					{Quantile: 1, Value: fields["upper"].(float64)},
+				},
+			})
+		}
+	}
+
+	return metrics
+}
+
+// export posts the encoded request to the endpoint.
+func (db *otlpDB) export(b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, db.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range db.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := db.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("snatch: otlp export failed: " + resp.Status)
+	}
+
+	// The export succeeded, so a response that cannot be decoded
+	// is not treated as a partial success.
+	var res collectormetricspb.ExportMetricsServiceResponse
+	if err := proto.Unmarshal(body, &res); err != nil {
+		return nil
+	}
+	if ps := res.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+		return fmt.Errorf("snatch: otlp rejected %d data points: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+	}
+
+	return nil
+}
+
+// Close closes the database.
+func (db *otlpDB) Close() error {
+	db.client.CloseIdleConnections()
+	return nil
+}
+
+// otlpAttributes converts the tags to attributes.
+func otlpAttributes(tags []string) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(tags)/2)
+	for i := 0; i+1 < len(tags); i += 2 {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   tags[i],
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: tags[i+1]}},
+		})
+	}
+
+	return attrs
+}
+
+// mapPairs returns the map as key value pairs, sorted by key.
+func mapPairs(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, 2*len(m))
+	for _, k := range keys {
+		pairs = append(pairs, k, m[k])
+	}
+
+	return pairs
+}
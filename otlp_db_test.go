@@ -0,0 +1,192 @@
+package snatch_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func serveOTLP(t *testing.T, status int, resp []byte) (*httptest.Server, chan *collectormetricspb.ExportMetricsServiceRequest) {
+	t.Helper()
+
+	reqs := make(chan *collectormetricspb.ExportMetricsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Api-Key"))
+
+		b, _ := ioutil.ReadAll(r.Body)
+		var data collectormetricspb.ExportMetricsServiceRequest
+		assert.NoError(t, proto.Unmarshal(b, &data))
+		reqs <- &data
+
+		w.WriteHeader(status)
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, reqs
+}
+
+func newOTLPDB(srv *httptest.Server, opts ...snatch.Option) snatch.DB {
+	return snatch.NewOTLPDB(snatch.OTLPOpts{
+		Endpoint: srv.URL + "/v1/metrics",
+		Headers:  map[string]string{"Api-Key": "secret"},
+		Resource: map[string]string{"host.name": "test"},
+	}, opts...)
+}
+
+func TestOTLPDB_Insert(t *testing.T) {
+	srv, reqs := serveOTLP(t, http.StatusOK, nil)
+	db := newOTLPDB(srv)
+	defer db.Close()
+	ts := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	bkts := []*snatch.Bucket{
+		{
+			ID:   &snatch.ID{Time: ts, Name: "req", Tags: []string{"host", "a"}, Type: snatch.Count, Res: 10 * time.Second},
+			Vals: []float64{1, 2},
+			Sum:  3,
+		},
+		{
+			ID:   &snatch.ID{Time: ts, Name: "mem", Type: snatch.Sample, Res: 10 * time.Second},
+			Vals: []float64{1, 2},
+			Sum:  3,
+		},
+		{
+			ID:    &snatch.ID{Time: ts, Name: "latency", Type: snatch.Measure, Res: 10 * time.Second},
+			Units: "ms",
+			Vals:  []float64{1, 2, 3, 4},
+			Sum:   10,
+		},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	data := <-reqs
+	assert.Len(t, data.ResourceMetrics, 1)
+	rm := data.ResourceMetrics[0]
+	assert.Len(t, rm.Resource.Attributes, 2)
+	assert.Equal(t, "host.name", rm.Resource.Attributes[0].Key)
+	assert.Equal(t, "test", rm.Resource.Attributes[0].Value.GetStringValue())
+	assert.Equal(t, "service.name", rm.Resource.Attributes[1].Key)
+	assert.Equal(t, "snatch", rm.Resource.Attributes[1].Value.GetStringValue())
+	assert.Equal(t, "github.com/nrwiersma/snatch", rm.ScopeMetrics[0].Scope.Name)
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	assert.Len(t, metrics, 3)
+	start := uint64(ts.UnixNano())
+	end := start + uint64(10*time.Second)
+
+	assert.Equal(t, "req", metrics[0].Name)
+	sum := metrics[0].GetSum()
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, sum.AggregationTemporality)
+	assert.True(t, sum.IsMonotonic)
+	assert.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, int64(3), sum.DataPoints[0].GetAsInt())
+	assert.Equal(t, start, sum.DataPoints[0].StartTimeUnixNano)
+	assert.Equal(t, end, sum.DataPoints[0].TimeUnixNano)
+	assert.Equal(t, "host", sum.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "a", sum.DataPoints[0].Attributes[0].Value.GetStringValue())
+
+	assert.Equal(t, "latency", metrics[1].Name)
+	assert.Equal(t, "ms", metrics[1].Unit)
+	summary := metrics[1].GetSummary()
+	assert.Len(t, summary.DataPoints, 1)
+	dp := summary.DataPoints[0]
+	assert.Equal(t, uint64(4), dp.Count)
+	assert.Equal(t, 10.0, dp.Sum)
+	var quantiles []float64
+	for _, q := range dp.QuantileValues {
+		quantiles = append(quantiles, q.Quantile, q.Value)
+	}
+	assert.Equal(t, []float64{0, 1, 0.9, 4, 0.95, 4, 0.97, 4, 0.99, 4, 1, 4}, quantiles)
+
+	assert.Equal(t, "mem", metrics[2].Name)
+	gauge := metrics[2].GetGauge()
+	assert.Len(t, gauge.DataPoints, 1)
+	assert.Equal(t, 2.0, gauge.DataPoints[0].GetAsDouble())
+}
+
+func TestOTLPDB_InsertGroupsDataPoints(t *testing.T) {
+	srv, reqs := serveOTLP(t, http.StatusOK, nil)
+	db := newOTLPDB(srv)
+	bkts := []*snatch.Bucket{
+		{ID: &snatch.ID{Time: time.Unix(0, 0), Name: "test", Tags: []string{"a", "1"}, Type: snatch.Count, Res: time.Second}, Vals: []float64{1}, Sum: 1},
+		{ID: &snatch.ID{Time: time.Unix(0, 0), Name: "test", Tags: []string{"a", "2"}, Type: snatch.Count, Res: time.Second}, Vals: []float64{2}, Sum: 2},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	metrics := (<-reqs).ResourceMetrics[0].ScopeMetrics[0].Metrics
+	assert.Len(t, metrics, 1)
+	assert.Len(t, metrics[0].GetSum().DataPoints, 2)
+}
+
+func TestOTLPDB_InsertExportsDeltasOnce(t *testing.T) {
+	srv, reqs := serveOTLP(t, http.StatusOK, nil)
+	db := newOTLPDB(srv)
+	first, merged := lateMergeScans(t)
+
+	err := db.Insert(first)
+	assert.NoError(t, err)
+	err = db.Insert(merged)
+
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 1)
+	sum := (<-reqs).ResourceMetrics[0].ScopeMetrics[0].Metrics[0].GetSum()
+	assert.Equal(t, int64(1), sum.DataPoints[0].GetAsInt())
+}
+
+func TestOTLPDB_InsertSkipsBucketsWithoutResolution(t *testing.T) {
+	srv, reqs := serveOTLP(t, http.StatusOK, nil)
+	db := newOTLPDB(srv)
+	bkts := []*snatch.Bucket{
+		{ID: &snatch.ID{Time: time.Unix(0, 0), Name: "test", Type: snatch.Count}, Vals: []float64{1}, Sum: 1},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 0)
+}
+
+func TestOTLPDB_InsertHandlesErrors(t *testing.T) {
+	partial, _ := proto.Marshal(&collectormetricspb.ExportMetricsServiceResponse{
+		PartialSuccess: &collectormetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: 2,
+			ErrorMessage:       "invalid name",
+		},
+	})
+
+	tests := []struct {
+		name   string
+		status int
+		resp   []byte
+		want   string
+	}{
+		{name: "status", status: http.StatusBadRequest, want: "snatch: otlp export failed: 400 Bad Request"},
+		{name: "partial success", status: http.StatusOK, resp: partial, want: "snatch: otlp rejected 2 data points: invalid name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := serveOTLP(t, tt.status, tt.resp)
+			db := newOTLPDB(srv)
+			first, _ := lateMergeScans(t)
+
+			err := db.Insert(first)
+
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
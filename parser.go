@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"github.com/kr/logfmt"
+	"math"
 	"strconv"
 	"time"
+
+	"github.com/kr/logfmt"
 )
 
 var (
@@ -18,11 +20,49 @@ var (
 	rateSeparator    = []byte{'@'}
 )
 
+// Parse error reasons.
+const (
+	// ReasonSyntax is a line that is not valid logfmt.
+	ReasonSyntax = "syntax"
+	// ReasonName is a metric without a name.
+	ReasonName = "name"
+	// ReasonValue is a metric with an invalid value.
+	ReasonValue = "value"
+	// ReasonType is a metric with an unknown type.
+	ReasonType = "type"
+	// ReasonNoMetrics is a line without metrics.
+	ReasonNoMetrics = "no_metrics"
+	// ReasonTime is a line with a missing or invalid event time.
+	ReasonTime = "time"
+)
+
+// ParseError represents a line that could not be parsed.
+type ParseError struct {
+	// Reason is the reason the line was rejected.
+	Reason string
+
+	msg string
+}
+
+// Error returns the error message.
+func (e *ParseError) Error() string {
+	return e.msg
+}
+
+// ParseErrorReason returns the reason of a parse error.
+func ParseErrorReason(err error) string {
+	if pe, ok := err.(*ParseError); ok {
+		return pe.Reason
+	}
+
+	return ReasonSyntax
+}
+
 type tuples []*tuple
 
 // HandleLogfmt implements the logfmt.Handler interface.
 func (t *tuples) HandleLogfmt(k, v []byte) error {
-	if bytes.Equal(k, timeKey) || bytes.Equal(k, levelKey) || bytes.Equal(k, msgKey) {
+	if bytes.Equal(k, levelKey) || bytes.Equal(k, msgKey) {
 		return nil
 	}
 
@@ -50,7 +90,7 @@ func (t *tuple) Float64() (float64, string, error) {
 	pos := 0
 	for i := range t.Val {
 		if (t.Val[i] >= '0' && t.Val[i] <= '9') ||
-			t.Val[i] == '.' || t.Val[i] == '-' {
+			t.Val[i] == '.' || t.Val[i] == '-' || t.Val[i] == '+' {
 			pos++
 			continue
 		}
@@ -72,6 +112,30 @@ func (t *tuple) Float64() (float64, string, error) {
 
 }
 
+// timeLayouts are the layouts accepted for line times.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+}
+
+// Time parses the value as a time, either formatted or as unix seconds.
+func (t *tuple) Time() (time.Time, error) {
+	s := t.String()
+	for _, layout := range timeLayouts {
+		if ts, err := time.Parse(layout, s); err == nil {
+			return ts, nil
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, errors.New("unable to parse time")
+	}
+
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
 type scanner struct {
 	Tuples tuples
 }
@@ -88,29 +152,53 @@ func (s *scanner) Reset() {
 
 // Parser parses l2met metrics.
 type Parser struct {
-	s   *scanner
-	res time.Duration
+	s         *scanner
+	res       time.Duration
+	rules     []ResolutionRule
+	eventTime bool
+	clock     Clock
 }
 
 // NewParser creates a new Parser instance.
-func NewParser(res time.Duration) *Parser {
+func NewParser(res time.Duration, opts ...Option) *Parser {
+	o := newOptions(opts)
+
 	return &Parser{
-		s:   &scanner{},
-		res: res,
+		s:         &scanner{},
+		res:       res,
+		rules:     o.rules,
+		eventTime: o.eventTime,
+		clock:     o.clock,
 	}
 }
 
 // Parse parses an l2met line returning metric Buckets.
 func (p *Parser) Parse(b []byte) ([]*Bucket, error) {
+	return p.parse(b, false)
+}
+
+// parse parses an l2met line returning metric Buckets. If the time is
+// required, lines without an event time are rejected.
+func (p *Parser) parse(b []byte, requireTime bool) ([]*Bucket, error) {
 	p.s.Reset()
 	if err := p.s.Scan(b); err != nil {
-		return nil, fmt.Errorf("parser: error parsing line: %s", err)
+		return nil, &ParseError{Reason: ReasonSyntax, msg: fmt.Sprintf("parser: error parsing line: %s", err)}
 	}
 
 	var ts time.Time
 	tags := make([]string, 0, len(p.s.Tuples)*2)
 	bkts := make([]*Bucket, 0, 2)
 	for _, t := range p.s.Tuples {
+		if bytes.Equal(t.Key, timeKey) {
+			if p.eventTime {
+				var err error
+				if ts, err = t.Time(); err != nil {
+					return nil, &ParseError{Reason: ReasonTime, msg: "parser: invalid time: " + t.String()}
+				}
+			}
+			continue
+		}
+
 		if bytes.Contains(t.Key, measureSeparator) {
 			bkt, err := p.parseMetric(t)
 			if err != nil {
@@ -123,15 +211,33 @@ func (p *Parser) Parse(b []byte) ([]*Bucket, error) {
 		tags = append(tags, t.Name(), t.String())
 	}
 
-	ts = time.Now().Truncate(p.res)
+	if ts.IsZero() {
+		if requireTime {
+			return nil, &ParseError{Reason: ReasonTime, msg: "parser: missing time"}
+		}
+		ts = p.clock.Now()
+	}
 	for _, bkt := range bkts {
-		bkt.ID.Time = ts
+		res := p.resOf(bkt.ID.Name)
+		bkt.ID.Time = ts.Truncate(res)
 		bkt.ID.Tags = tags
+		bkt.ID.Res = res
 	}
 
 	return bkts, nil
 }
 
+// resOf returns the resolution of the metric name.
+func (p *Parser) resOf(name string) time.Duration {
+	for _, r := range p.rules {
+		if r.Match(name) {
+			return r.Res
+		}
+	}
+
+	return p.res
+}
+
 func (p *Parser) parseMetric(t *tuple) (*Bucket, error) {
 	split := bytes.SplitN(t.Key, measureSeparator, 2)
 	id := &ID{
@@ -139,7 +245,7 @@ func (p *Parser) parseMetric(t *tuple) (*Bucket, error) {
 	}
 
 	if len(split[1]) == 0 {
-		return nil, errors.New("parser: zero length name")
+		return nil, &ParseError{Reason: ReasonName, msg: "parser: zero length name"}
 	}
 
 	name, rate := p.splitRate(split[1])
@@ -151,7 +257,7 @@ func (p *Parser) parseMetric(t *tuple) (*Bucket, error) {
 
 	v, units, err := t.Float64()
 	if err != nil {
-		return nil, errors.New("parser: invalid float value: " + t.String())
+		return nil, &ParseError{Reason: ReasonValue, msg: "parser: invalid float value: " + t.String()}
 	}
 	bkt.Units = units
 
@@ -163,6 +269,8 @@ func (p *Parser) parseMetric(t *tuple) (*Bucket, error) {
 		bkt.Append(v)
 
 	case Sample:
+		// A signed sample adjusts the current value.
+		bkt.Relative = t.Val[0] == '+' || t.Val[0] == '-'
 		bkt.Append(v)
 
 	case Measure:
@@ -175,7 +283,7 @@ func (p *Parser) parseMetric(t *tuple) (*Bucket, error) {
 		}
 
 	default:
-		return nil, errors.New("parser: invalid metric type: " + string(split[0]))
+		return nil, &ParseError{Reason: ReasonType, msg: "parser: invalid metric type: " + string(split[0])}
 	}
 
 	return bkt, nil
@@ -54,6 +54,27 @@ func TestParser_ParseErrorsOnInvalidMetrics(t *testing.T) {
 	}
 }
 
+func TestParser_ParseErrorReasons(t *testing.T) {
+	tests := []struct {
+		metric []byte
+		reason string
+	}{
+		{[]byte("count#test=test"), snatch.ReasonValue},
+		{[]byte("foo#test=1.2"), snatch.ReasonType},
+		{[]byte("count#=1.2"), snatch.ReasonName},
+		{[]byte("count#test=\"1.2"), snatch.ReasonSyntax},
+		{[]byte("t=yesterday count#test=1"), snatch.ReasonTime},
+	}
+
+	for _, tt := range tests {
+		p := snatch.NewParser(time.Second, snatch.WithEventTime())
+
+		_, err := p.Parse(tt.metric)
+
+		assert.Equal(t, tt.reason, snatch.ParseErrorReason(err))
+	}
+}
+
 func TestParser_ParseHandlesCount(t *testing.T) {
 	m := []byte("count#prefix.test=2")
 	p := snatch.NewParser(30 * time.Second)
@@ -78,6 +99,30 @@ func TestParser_ParseHandlesSample(t *testing.T) {
 	assert.Equal(t, []float64{2.5}, bkts[0].Vals)
 }
 
+func TestParser_ParseHandlesRelativeSample(t *testing.T) {
+	tests := []struct {
+		metric   []byte
+		vals     []float64
+		relative bool
+	}{
+		{metric: []byte("sample#queue=5"), vals: []float64{5}, relative: false},
+		{metric: []byte("sample#queue=+5"), vals: []float64{5}, relative: true},
+		{metric: []byte("sample#queue=-3"), vals: []float64{-3}, relative: true},
+		{metric: []byte("count#queue=-3"), vals: []float64{-3}, relative: false},
+	}
+
+	for _, tt := range tests {
+		p := snatch.NewParser(time.Second)
+
+		bkts, err := p.Parse(tt.metric)
+
+		assert.NoError(t, err)
+		assert.Len(t, bkts, 1)
+		assert.Equal(t, tt.vals, bkts[0].Vals)
+		assert.Equal(t, tt.relative, bkts[0].Relative)
+	}
+}
+
 func TestParser_ParseHandlesMeasure(t *testing.T) {
 	m := []byte("measure#prefix.test=2.545ms")
 	p := snatch.NewParser(30 * time.Second)
@@ -102,6 +147,77 @@ func TestParser_ParseHandlesBadTime(t *testing.T) {
 	assert.Equal(t, time.Now().Truncate(time.Minute), bkts[0].ID.Time.Truncate(time.Minute))
 }
 
+func TestParser_ParseUsesClock(t *testing.T) {
+	m := []byte("lvl=info msg= count#test=2")
+	clock := snatch.NewFakeClock(time.Date(2018, 10, 10, 10, 10, 12, 0, time.UTC))
+	p := snatch.NewParser(5*time.Second, snatch.WithClock(clock))
+
+	bkts, err := p.Parse(m)
+
+	assert.NoError(t, err)
+	assert.Len(t, bkts, 1)
+	assert.Equal(t, time.Date(2018, 10, 10, 10, 10, 10, 0, time.UTC), bkts[0].ID.Time)
+}
+
+func TestParser_ParseHandlesResolutionRules(t *testing.T) {
+	m := []byte("lvl=info msg= count#incident.errors=1 count#requests=1")
+	p := snatch.NewParser(time.Minute, snatch.WithResolutionRules(
+		snatch.ResolutionRule{Pattern: "incident.*", Res: time.Second},
+	))
+
+	bkts, err := p.Parse(m)
+
+	assert.NoError(t, err)
+	assert.Len(t, bkts, 2)
+	assert.Equal(t, time.Second, bkts[0].ID.Res)
+	assert.Equal(t, bkts[0].ID.Time, bkts[0].ID.Time.Truncate(time.Second))
+	assert.Equal(t, time.Minute, bkts[1].ID.Res)
+	assert.Equal(t, bkts[1].ID.Time, bkts[1].ID.Time.Truncate(time.Minute))
+}
+
+func TestParser_ParseHandlesEventTime(t *testing.T) {
+	tests := []struct {
+		metric []byte
+		want   time.Time
+	}{
+		{
+			metric: []byte("t=2018-10-10T10:10:12Z count#test=2"),
+			want:   time.Date(2018, 10, 10, 10, 10, 10, 0, time.UTC),
+		},
+		{
+			metric: []byte("t=2018-10-10T12:10:12+0200 count#test=2"),
+			want:   time.Date(2018, 10, 10, 10, 10, 10, 0, time.UTC),
+		},
+		{
+			metric: []byte("t=1539166212 count#test=2"),
+			want:   time.Date(2018, 10, 10, 10, 10, 10, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		p := snatch.NewParser(5*time.Second, snatch.WithEventTime())
+
+		bkts, err := p.Parse(tt.metric)
+
+		assert.NoError(t, err)
+		assert.Len(t, bkts, 1)
+		assert.True(t, tt.want.Equal(bkts[0].ID.Time), string(tt.metric))
+		assert.Len(t, bkts[0].ID.Tags, 0)
+	}
+}
+
+func TestParser_ParseIgnoresEventTimeByDefault(t *testing.T) {
+	m := []byte("t=2018-10-10T10:10:12Z count#test=2")
+	p := snatch.NewParser(time.Second)
+
+	bkts, err := p.Parse(m)
+
+	assert.NoError(t, err)
+	assert.Len(t, bkts, 1)
+	assert.Equal(t, time.Now().Truncate(time.Minute), bkts[0].ID.Time.Truncate(time.Minute))
+	assert.Len(t, bkts[0].ID.Tags, 0)
+}
+
 func TestParser_ParseHandlesTags(t *testing.T) {
 	m := []byte("lvl=info msg= count#test=2 foo=\"bar\" size=10 test=test")
 	p := snatch.NewParser(time.Second)
@@ -0,0 +1,415 @@
+package snatch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// promQuantiles maps the Measure percentile fields to summary quantiles.
+var promQuantiles = []struct {
+	field    string
+	quantile string
+}{
+	{field: "90_percentile", quantile: "0.9"},
+	{field: "95_percentile", quantile: "0.95"},
+	{field: "97_percentile", quantile: "0.97"},
+	{field: "99_percentile", quantile: "0.99"},
+}
+
+// promLabel is a Prometheus label.
+type promLabel struct {
+	name  string
+	value string
+}
+
+// promSample is the value of a series after adding a Bucket.
+type promSample struct {
+	name   string
+	labels []promLabel
+	value  float64
+}
+
+type promFamily struct {
+	typ    Type
+	unit   string
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	labels []promLabel
+	// res is the resolution of the series, and seen the time it was
+	// last updated, to expire it.
+	res  time.Duration
+	seen time.Time
+	// value is the total of a counter, or the value of a gauge.
+	value     float64
+	quantiles map[string]float64
+	sum       float64
+	count     int64
+}
+
+// promMetrics accumulates Buckets into Prometheus metric families,
+// as described by NewPromDB.
+type promMetrics struct {
+	families map[string]*promFamily
+	expire   int
+
+	agg   SampleAgg
+	clock Clock
+}
+
+// defaultPromExpire is the default number of intervals after which
+// a series that is not updated is removed.
+const defaultPromExpire = 10
+
+func newPromMetrics(expire int, o *options) *promMetrics {
+	if expire == 0 {
+		expire = defaultPromExpire
+	}
+
+	return &promMetrics{
+		families: map[string]*promFamily{},
+		expire:   expire,
+		agg:      o.sampleAgg,
+		clock:    o.clock,
+	}
+}
+
+// PromOpts configures the writing of metrics for the textfile collector.
+type PromOpts struct {
+	// Expire is the number of intervals of its resolution after which a
+	// series that is not updated is removed. It defaults to 10, series
+	// are never removed if it is negative.
+	Expire int
+}
+
+type promDB struct {
+	mu      sync.Mutex
+	path    string
+	metrics *promMetrics
+}
+
+// NewPromDB creates a DB that writes Buckets to a file in the OpenMetrics
+// text format, for the node_exporter textfile collector.
+//
+// The file is atomically rewritten on each Insert. Count Buckets are
+// accumulated into counters, Sample Buckets become gauges and Measure
+// Buckets become summaries, with the quantiles of the latest Bucket and
+// the accumulated sum and count. Rollups are not written, as Prometheus
+// aggregates over time itself.
+//
+// Merged Buckets are ignored, as their interval was already added to the
+// counters and summaries, and is older than the current gauge values.
+//
+// Series that are not updated for a number of intervals are removed,
+// restarting their counters. Series of Buckets without a resolution,
+// such as the Application's own metrics, are never removed.
+func NewPromDB(path string, popts PromOpts, opts ...Option) (DB, error) {
+	o := newOptions(opts)
+
+	db := &promDB{
+		path:    path,
+		metrics: newPromMetrics(popts.Expire, o),
+	}
+
+	if err := db.write(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Insert adds the Buckets to the metrics and rewrites the file.
+func (db *promDB) Insert(bkts []*Bucket) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, bkt := range sortBuckets(bkts) {
+		db.metrics.add(bkt)
+	}
+	db.metrics.expireSeries()
+
+	return db.write()
+}
+
+// write atomically rewrites the file with the current metrics.
+func (db *promDB) write() error {
+	var buf bytes.Buffer
+	db.metrics.encode(&buf)
+
+	dir, base := filepath.Split(db.path)
+	f, err := os.CreateTemp(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), db.path)
+}
+
+// Close closes the database.
+func (db *promDB) Close() error {
+	return nil
+}
+
+// add adds a Bucket to the metrics, returning the resulting samples.
+// Buckets with the name of a family of another type are ignored, as
+// are merged Buckets, which were already added.
+func (m *promMetrics) add(bkt *Bucket) []promSample {
+	if bkt.ID.Rollup || bkt.Merged {
+		return nil
+	}
+
+	name := promName(pointName(bkt, Rollup{}))
+	unit := promName(bkt.Units)
+	if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+	labels := promLabels(bkt.ID.Tags)
+	fields := bkt.Fields(m.agg)
+
+	var samples []promSample
+	switch bkt.ID.Type {
+	case Count:
+		name = strings.TrimSuffix(name, "_total")
+		s := m.series(name, Count, unit, labels, bkt.ID.Res)
+		if s == nil {
+			return nil
+		}
+		s.value += float64(fields["value"].(int64))
+
+		samples = append(samples, promSample{name: name + "_total", labels: labels, value: s.value})
+
+	case Sample:
+		for _, k := range sortedKeys(fields) {
+			n := name
+			if k != "value" {
+				n += "_" + k
+			}
+
+			s := m.series(n, Sample, unit, labels, bkt.ID.Res)
+			if s == nil {
+				continue
+			}
+			s.value = fields[k].(float64)
+
+			samples = append(samples, promSample{name: n, labels: labels, value: s.value})
+		}
+
+	case Measure:
+		if len(bkt.Vals) == 0 {
+			return nil
+		}
+		s := m.series(name, Measure, unit, labels, bkt.ID.Res)
+		if s == nil {
+			return nil
+		}
+		for _, q := range promQuantiles {
+			s.quantiles[q.quantile] = fields[q.field].(float64)
+		}
+		s.sum += bkt.Sum
+		s.count += int64(len(bkt.Vals))
+
+		for _, q := range promQuantiles {
+			samples = append(samples, promSample{
+				name:   name,
+				labels: withPromLabel(labels, promLabel{name: "quantile", value: q.quantile}),
+				value:  s.quantiles[q.quantile],
+			})
+		}
+		samples = append(samples,
+			promSample{name: name + "_sum", labels: labels, value: s.sum},
+			promSample{name: name + "_count", labels: labels, value: float64(s.count)},
+		)
+	}
+
+	return samples
+}
+
+// series returns the series of a family, creating it if required, and
+// marks it as updated. If the family is of another type, nil is returned.
+func (m *promMetrics) series(name string, typ Type, unit string, labels []promLabel, res time.Duration) *promSeries {
+	f, ok := m.families[name]
+	if !ok {
+		f = &promFamily{typ: typ, unit: unit, series: map[string]*promSeries{}}
+		m.families[name] = f
+	}
+	if f.typ != typ {
+		return nil
+	}
+
+	key := renderPromLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &promSeries{labels: labels, quantiles: map[string]float64{}}
+		f.series[key] = s
+	}
+	s.res = res
+	s.seen = m.clock.Now()
+
+	return s
+}
+
+// expireSeries removes the series that were not updated for the
+// configured number of intervals, and families without series.
+func (m *promMetrics) expireSeries() {
+	if m.expire < 0 {
+		return
+	}
+
+	now := m.clock.Now()
+	for name, f := range m.families {
+		for key, s := range f.series {
+			if s.res > 0 && now.Sub(s.seen) > time.Duration(m.expire)*s.res {
+				delete(f.series, key)
+			}
+		}
+		if len(f.series) == 0 {
+			delete(m.families, name)
+		}
+	}
+}
+
+// encode writes the metrics in the OpenMetrics text format.
+func (m *promMetrics) encode(buf *bytes.Buffer) {
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+
+		buf.WriteString("# TYPE " + name + " " + promType(f.typ) + "\n")
+		if f.unit != "" {
+			buf.WriteString("# UNIT " + name + " " + f.unit + "\n")
+		}
+
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+
+			switch f.typ {
+			case Count:
+				writePromSample(buf, name+"_total", s.labels, s.value)
+
+			case Sample:
+				writePromSample(buf, name, s.labels, s.value)
+
+			case Measure:
+				for _, q := range promQuantiles {
+					labels := withPromLabel(s.labels, promLabel{name: "quantile", value: q.quantile})
+					writePromSample(buf, name, labels, s.quantiles[q.quantile])
+				}
+				writePromSample(buf, name+"_sum", s.labels, s.sum)
+				writePromSample(buf, name+"_count", s.labels, float64(s.count))
+			}
+		}
+	}
+
+	buf.WriteString("# EOF\n")
+}
+
+func writePromSample(buf *bytes.Buffer, name string, labels []promLabel, v float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		buf.WriteString(renderPromLabels(labels))
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatField(v))
+	buf.WriteByte('\n')
+}
+
+// promType returns the metric type of a Bucket type.
+func promType(typ Type) string {
+	switch typ {
+	case Count:
+		return "counter"
+	case Measure:
+		return "summary"
+	default:
+		return "gauge"
+	}
+}
+
+// promLabels converts the tags to labels, sorted by name.
+func promLabels(tags []string) []promLabel {
+	labels := make([]promLabel, 0, len(tags)/2)
+	for i := 0; i+1 < len(tags); i += 2 {
+		labels = append(labels, promLabel{name: promLabelName(tags[i]), value: tags[i+1]})
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+
+	return labels
+}
+
+// withPromLabel returns a copy of the labels with the label appended.
+func withPromLabel(labels []promLabel, l promLabel) []promLabel {
+	return append(append(make([]promLabel, 0, len(labels)+1), labels...), l)
+}
+
+// renderPromLabels renders the labels in the text format.
+func renderPromLabels(labels []promLabel) string {
+	strs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		strs = append(strs, l.name+`="`+promEscaper.Replace(l.value)+`"`)
+	}
+
+	return strings.Join(strs, ",")
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabelName replaces the characters that are invalid in a label
+// name with underscores. Names reserved for internal use are prefixed.
+func promLabelName(s string) string {
+	name := strings.Replace(promName(s), ":", "_", -1)
+	if strings.HasPrefix(name, "__") {
+		name = "tag" + name
+	}
+
+	return name
+}
+
+// promName replaces the characters that are invalid in a
+// metric or label name with underscores.
+func promName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c == '_' || c == ':',
+			c >= 'a' && c <= 'z',
+			c >= 'A' && c <= 'Z',
+			c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
@@ -0,0 +1,147 @@
+package snatch_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPromDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snatch.prom")
+
+	_, err := snatch.NewPromDB(path, snatch.PromOpts{})
+
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "# EOF\n", string(b))
+}
+
+func TestNewPromDB_InvalidPath(t *testing.T) {
+	_, err := snatch.NewPromDB(filepath.Join(t.TempDir(), "foo", "snatch.prom"), snatch.PromOpts{})
+
+	assert.Error(t, err)
+}
+
+func TestPromDB_Insert(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "snatch.prom")
+	db, _ := snatch.NewPromDB(path, snatch.PromOpts{})
+
+	err := db.Insert(writerBuckets())
+	assert.NoError(t, err)
+	err = db.Insert(writerBuckets())
+	assert.NoError(t, err)
+
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, `# TYPE foo_counter counter
+foo_counter_total{tag="example"} 20
+# TYPE foo_measure_ms summary
+# UNIT foo_measure_ms ms
+foo_measure_ms{tag="a b",quantile="0.9"} 4
+foo_measure_ms{tag="a b",quantile="0.95"} 4
+foo_measure_ms{tag="a b",quantile="0.97"} 4
+foo_measure_ms{tag="a b",quantile="0.99"} 4
+foo_measure_ms_sum{tag="a b"} 20
+foo_measure_ms_count{tag="a b"} 8
+# TYPE foo_sample gauge
+foo_sample{tag="example"} 2
+# EOF
+`, string(b))
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{path}, files)
+}
+
+func TestPromDB_InsertSanitizesNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snatch.prom")
+	db, _ := snatch.NewPromDB(path, snatch.PromOpts{}, snatch.WithSampleAggregation(snatch.SampleAll))
+	bkts := []*snatch.Bucket{
+		{
+			ID:   &snatch.ID{Time: time.Unix(0, 0), Name: "1req-s.total", Tags: []string{"my-tag", "a\"b\\c"}, Type: snatch.Count},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID:   &snatch.ID{Time: time.Unix(0, 0), Name: "mem", Type: snatch.Sample},
+			Vals: []float64{1, 2},
+			Sum:  3,
+		},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, `# TYPE _req_s counter
+_req_s_total{my_tag="a\"b\\c"} 1
+# TYPE mem_first gauge
+mem_first 1
+# TYPE mem_last gauge
+mem_last 2
+# TYPE mem_max gauge
+mem_max 2
+# TYPE mem_mean gauge
+mem_mean 1.5
+# TYPE mem_min gauge
+mem_min 1
+# EOF
+`, string(b))
+}
+
+func TestPromDB_InsertSkipsRollupsAndTypeConflicts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snatch.prom")
+	r, _ := snatch.ParseRollup("1m")
+	db, _ := snatch.NewPromDB(path, snatch.PromOpts{}, snatch.WithRollups(r))
+	bkts := []*snatch.Bucket{
+		{
+			ID:   &snatch.ID{Time: time.Unix(0, 0), Name: "test", Type: snatch.Count},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID:   &snatch.ID{Time: time.Unix(0, 0), Name: "test", Type: snatch.Count, Res: time.Minute, Rollup: true},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+		{
+			ID:   &snatch.ID{Time: time.Unix(0, 0), Name: "test", Type: snatch.Sample},
+			Vals: []float64{1},
+			Sum:  1,
+		},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "# TYPE test counter\ntest_total 1\n# EOF\n", string(b))
+}
+
+func TestPromDB_InsertExpiresSeries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snatch.prom")
+	clock := snatch.NewFakeClock(time.Unix(0, 0))
+	db, _ := snatch.NewPromDB(path, snatch.PromOpts{Expire: 2}, snatch.WithClock(clock))
+	bkt := func(name string, res time.Duration) *snatch.Bucket {
+		return &snatch.Bucket{
+			ID:   &snatch.ID{Time: clock.Now(), Name: name, Type: snatch.Count, Res: res},
+			Vals: []float64{1},
+			Sum:  1,
+		}
+	}
+
+	_ = db.Insert([]*snatch.Bucket{bkt("old", 10*time.Second), bkt("self", 0)})
+	clock.Add(20 * time.Second)
+	_ = db.Insert([]*snatch.Bucket{bkt("new", 10*time.Second)})
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "# TYPE new counter\nnew_total 1\n# TYPE old counter\nold_total 1\n# TYPE self counter\nself_total 1\n# EOF\n", string(b))
+
+	clock.Add(time.Second)
+	err := db.Insert(nil)
+
+	assert.NoError(t, err)
+	b, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "# TYPE new counter\nnew_total 1\n# TYPE self counter\nself_total 1\n# EOF\n", string(b))
+}
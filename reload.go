@@ -0,0 +1,95 @@
+package snatch
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// Components are the replaceable components of an Application.
+type Components struct {
+	// Res is the time resolution of metrics.
+	Res time.Duration
+	// DB is the database Buckets are inserted into, or nil to keep
+	// the current database.
+	DB DB
+	// Store is the Bucket store, or nil to keep the current Store.
+	Store Store
+	// Opts are the options of the Application.
+	Opts []Option
+}
+
+// Reload replaces the components of the Application.
+//
+// When the database or Store is replaced, parsing and scanning are
+// paused while the complete Buckets are inserted into the current
+// database, after which the components are swapped in. The partial
+// Buckets of a replaced Store are moved into the new Store, unless the
+// resolution changes or either Store does not support snapshots, in
+// which case they are flushed into the current database. If this fails,
+// the current components are kept. Otherwise only the options are
+// applied, keeping the Buckets held by the Store. A new resolution
+// requires a new Store. The caller is responsible for closing the
+// replaced components.
+func (a *Application) Reload(c Components) error {
+	a.rw.Lock()
+	defer a.rw.Unlock()
+
+	if c.DB == nil {
+		c.DB = a.db
+	}
+	if c.Store == nil {
+		if c.Res != a.res {
+			return errors.New("snatch: a new resolution requires a new store")
+		}
+		c.Store = a.s
+	}
+
+	if c.DB != a.db || c.Store != a.s {
+		if err := a.handOff(c.Res, c.Store); err != nil {
+			return err
+		}
+	}
+
+	a.configure(c.Res, c.DB, c.Store, c.Opts)
+
+	return nil
+}
+
+// handOff inserts the complete Buckets into the current database, and
+// moves the partial Buckets into the new Store.
+func (a *Application) handOff(res time.Duration, s Store) error {
+	if err := a.scanStore(); err != nil {
+		return err
+	}
+	if s == a.s {
+		return nil
+	}
+
+	from, ok := a.s.(Snapshotter)
+	to, toOK := s.(Snapshotter)
+	if !ok || !toOK || res != a.res {
+		return a.flush()
+	}
+
+	var buf bytes.Buffer
+	if err := from.Snapshot(&buf); err != nil {
+		return err
+	}
+	if err := to.Restore(&buf); err != nil {
+		return err
+	}
+
+	// Empty the current Store, so its log no longer holds the moved Buckets.
+	out, err := a.s.Flush()
+	if err != nil {
+		return err
+	}
+	for range out {
+	}
+	if c, ok := a.s.(Committer); ok {
+		return c.Commit()
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package snatch_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestApplication_Reload(t *testing.T) {
+	oldDB := new(mockDB)
+	oldDB.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 0
+	})).Return(nil).Once()
+	oldDB.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 1 && bkts[0].ID.Name == "old"
+	})).Return(nil).Once()
+	oldStore := snatch.NewStore(10 * time.Second)
+	app := snatch.NewApplication(10*time.Second, oldDB, oldStore)
+	opts := snatch.ParseOpts{BufferSize: 1, AllowedPending: 10}
+	_ = app.Parse(bytes.NewReader([]byte("count#old=1\n")), opts, func([]byte) {})
+
+	newDB := new(mockDB)
+	newDB.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 1 && bkts[0].ID.Name == "new" && bkts[0].ID.Res == time.Minute
+	})).Return(nil).Once()
+	newStore := snatch.NewStore(time.Minute)
+
+	err := app.Reload(snatch.Components{Res: time.Minute, DB: newDB, Store: newStore})
+	assert.NoError(t, err)
+
+	_ = app.Parse(bytes.NewReader([]byte("count#new=1\n")), opts, func([]byte) {})
+	err = app.Flush()
+
+	assert.NoError(t, err)
+	oldDB.AssertExpectations(t)
+	newDB.AssertExpectations(t)
+}
+
+func TestApplication_ReloadMovesPartialBuckets(t *testing.T) {
+	oldDB := new(mockDB)
+	oldDB.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 0
+	})).Return(nil).Once()
+	clock := snatch.NewFakeClock(time.Now().Truncate(10 * time.Second))
+	app := snatch.NewApplication(10*time.Second, oldDB, snatch.NewStore(10*time.Second, snatch.WithClock(clock)), snatch.WithClock(clock))
+	opts := snatch.ParseOpts{BufferSize: 1, AllowedPending: 10}
+	_ = app.Parse(bytes.NewReader([]byte("count#test=1\n")), opts, func([]byte) {})
+
+	newDB := new(mockDB)
+	newDB.On("Insert", mock.MatchedBy(func(bkts []*snatch.Bucket) bool {
+		return len(bkts) == 1 && bkts[0].Sum == 2
+	})).Return(nil).Once()
+
+	newStore := snatch.NewStore(10*time.Second, snatch.WithClock(clock))
+	err := app.Reload(snatch.Components{Res: 10 * time.Second, DB: newDB, Store: newStore, Opts: []snatch.Option{snatch.WithClock(clock)}})
+	assert.NoError(t, err)
+
+	_ = app.Parse(bytes.NewReader([]byte("count#test=1\n")), opts, func([]byte) {})
+	err = app.Flush()
+
+	assert.NoError(t, err)
+	oldDB.AssertExpectations(t)
+	newDB.AssertExpectations(t)
+}
+
+func TestApplication_ReloadKeepsBucketsWhenOnlyOptionsChange(t *testing.T) {
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	s := snatch.NewStore(10 * time.Second)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	opts := snatch.ParseOpts{BufferSize: 1, AllowedPending: 10}
+	_ = app.Parse(bytes.NewReader([]byte("count#test=1\n")), opts, func([]byte) {})
+
+	err := app.Reload(snatch.Components{Res: 10 * time.Second, Opts: []snatch.Option{snatch.WithEventTime()}})
+	assert.NoError(t, err)
+	db.AssertNotCalled(t, "Insert", mock.Anything)
+
+	_ = app.Parse(bytes.NewReader([]byte("count#test=1\n")), opts, func([]byte) {})
+	err = app.Flush()
+
+	assert.NoError(t, err)
+	db.AssertNumberOfCalls(t, "Insert", 1)
+	assert.Equal(t, 1, app.Stats().Series)
+}
+
+func TestApplication_ReloadRequiresStoreForNewResolution(t *testing.T) {
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	s := snatch.NewStore(10 * time.Second)
+	app := snatch.NewApplication(10*time.Second, db, s)
+
+	err := app.Reload(snatch.Components{Res: time.Minute})
+	assert.Error(t, err)
+
+	opts := snatch.ParseOpts{BufferSize: 1, AllowedPending: 10}
+	_ = app.Parse(bytes.NewReader([]byte("count#test=1\n")), opts, func([]byte) {})
+	err = app.Flush()
+
+	assert.NoError(t, err)
+	db.AssertNumberOfCalls(t, "Insert", 1)
+	assert.Equal(t, 1, app.Stats().Series)
+}
+
+func TestApplication_ReloadDoesNotSwapOnFlushError(t *testing.T) {
+	out := make(chan *snatch.Bucket)
+	close(out)
+
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(errors.New("test"))
+	s := new(mockStore)
+	s.On("Scan").Return(out, nil)
+	s.On("Flush").Return(out, nil)
+	app := snatch.NewApplication(10*time.Second, db, s)
+	newDB := new(mockDB)
+
+	err := app.Reload(snatch.Components{Res: 10 * time.Second, DB: newDB})
+	assert.EqualError(t, err, "test")
+
+	_ = app.Flush()
+
+	db.AssertNumberOfCalls(t, "Insert", 2)
+	newDB.AssertNotCalled(t, "Insert", mock.Anything)
+}
+
+func TestApplication_ReloadWhileRunning(t *testing.T) {
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	app := snatch.NewApplication(time.Second, db, snatch.NewStore(time.Second))
+	r, w := io.Pipe()
+
+	done := make(chan error)
+	go func() {
+		opts := snatch.RunOpts{ParseOpts: snatch.ParseOpts{BufferSize: 1, AllowedPending: 100}}
+		done <- app.Run(context.Background(), r, opts)
+	}()
+
+	for i := 0; i < 10; i++ {
+		_, _ = w.Write([]byte("count#test=1\n"))
+		err := app.Reload(snatch.Components{Res: time.Second, DB: db, Store: snatch.NewStore(time.Second)})
+		assert.NoError(t, err)
+	}
+	_ = w.Close()
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, int64(10), app.Stats().LinesParsed)
+}
@@ -0,0 +1,204 @@
+package snatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteOpts configures the writing of metrics with the Prometheus
+// remote write protocol.
+type RemoteWriteOpts struct {
+	// URL is the remote write endpoint,
+	// e.g. "http://localhost:9009/api/v1/push".
+	URL string
+	// Headers are added to each request.
+	Headers map[string]string
+	// Retries is the number of times a request is retried when the
+	// endpoint fails with a 5xx status or cannot be reached.
+	Retries int
+	// Backoff is the wait before the first retry, doubling with each
+	// retry. It defaults to 100ms.
+	Backoff time.Duration
+	// Client is the HTTP client used, with a 10 second timeout by default.
+	Client *http.Client
+}
+
+// rwSeries is a remote write time series.
+type rwSeries struct {
+	labels  []promLabel
+	samples []rwSample
+}
+
+// rwSample is a remote write sample.
+type rwSample struct {
+	value float64
+	// ts is the time in milliseconds.
+	ts int64
+}
+
+type remoteWriteDB struct {
+	mu      sync.Mutex
+	url     string
+	headers map[string]string
+	retries int
+	backoff time.Duration
+	client  *http.Client
+
+	metrics *promMetrics
+}
+
+// NewRemoteWriteDB creates a DB that writes Buckets to a Prometheus
+// remote write endpoint, as snappy compressed protobuf.
+//
+// Buckets are converted to series as in NewPromDB, with each sample
+// timestamped with the time of its Bucket.
+func NewRemoteWriteDB(rw RemoteWriteOpts, opts ...Option) DB {
+	o := newOptions(opts)
+
+	backoff := rw.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	client := rw.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &remoteWriteDB{
+		url:     rw.URL,
+		headers: rw.Headers,
+		retries: rw.Retries,
+		backoff: backoff,
+		client:  client,
+		metrics: newPromMetrics(0, o),
+	}
+}
+
+// Insert writes the Buckets to the endpoint.
+func (db *remoteWriteDB) Insert(bkts []*Bucket) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var series []*rwSeries
+	byKey := map[string]*rwSeries{}
+	for _, bkt := range sortBuckets(bkts) {
+		ts := bkt.ID.Time.UnixNano() / int64(time.Millisecond)
+
+		for _, s := range db.metrics.add(bkt) {
+			labels := withPromLabel(s.labels, promLabel{name: "__name__", value: s.name})
+			sort.SliceStable(labels, func(i, j int) bool {
+				return labels[i].name < labels[j].name
+			})
+
+			key := renderPromLabels(labels)
+			rs, ok := byKey[key]
+			if !ok {
+				rs = &rwSeries{labels: labels}
+				byKey[key] = rs
+				series = append(series, rs)
+			}
+			rs.samples = append(rs.samples, rwSample{value: s.value, ts: ts})
+		}
+	}
+	db.metrics.expireSeries()
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	return db.send(snappy.Encode(nil, encodeWriteRequest(series)))
+}
+
+// send posts the request, retrying server errors.
+func (db *remoteWriteDB) send(b []byte) error {
+	backoff := db.backoff
+	for i := 0; ; i++ {
+		retry, err := db.post(b)
+		if err == nil || !retry || i >= db.retries {
+			return err
+		}
+
+		// The wall clock is used, as the Clock may be driven by the data.
+		<-time.After(backoff)
+		backoff *= 2
+	}
+}
+
+// post posts the request, returning if it can be retried on error.
+func (db *remoteWriteDB) post(b []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, db.url, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("User-Agent", "snatch")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range db.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := db.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("snatch: remote write failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode >= 500, err
+}
+
+// Close closes the database.
+func (db *remoteWriteDB) Close() error {
+	db.client.CloseIdleConnections()
+	return nil
+}
+
+// encodeWriteRequest encodes the series as a remote write WriteRequest.
+func encodeWriteRequest(series []*rwSeries) []byte {
+	var b []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		for _, smpl := range s.samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(smpl.value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(smpl.ts))
+
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+
+	return b
+}
@@ -0,0 +1,253 @@
+package snatch_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func serveRemoteWrite(t *testing.T, statuses ...int) (*httptest.Server, chan []string, *int32) {
+	t.Helper()
+
+	var calls int32
+	reqs := make(chan []string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+
+		b, _ := ioutil.ReadAll(r.Body)
+		b, err := snappy.Decode(nil, b)
+		assert.NoError(t, err)
+		reqs <- decodeWriteRequest(t, b)
+
+		status := http.StatusNoContent
+		if n := int(atomic.AddInt32(&calls, 1)); n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, reqs, &calls
+}
+
+// decodeWriteRequest decodes a WriteRequest, rendering each sample
+// as "labels value@ts".
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+
+	var out []string
+	for _, ts := range wireMessages(t, b, 1) {
+		var labels []string
+		for _, l := range wireMessages(t, ts, 1) {
+			f := wireFields(t, l)
+			labels = append(labels, fmt.Sprintf("%s=%q", f[1], f[2]))
+		}
+		for _, s := range wireMessages(t, ts, 2) {
+			f := wireFields(t, s)
+			v := math.Float64frombits(f[1].(uint64))
+			out = append(out, fmt.Sprintf("{%s} %v@%d", strings.Join(labels, ","), v, f[2]))
+		}
+	}
+
+	return out
+}
+
+// wireMessages returns the embedded messages of field num.
+func wireMessages(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	var msgs [][]byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		assert.True(t, l > 0)
+		b = b[l:]
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			msgs = append(msgs, v)
+			b = b[l:]
+			continue
+		}
+		b = b[protowire.ConsumeFieldValue(n, typ, b):]
+	}
+
+	return msgs
+}
+
+// wireFields decodes the scalar fields of a message.
+func wireFields(t *testing.T, b []byte) map[protowire.Number]interface{} {
+	f := map[protowire.Number]interface{}{}
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		assert.True(t, l > 0)
+		b = b[l:]
+		switch typ {
+		case protowire.BytesType:
+			v, l := protowire.ConsumeBytes(b)
+			f[n] = string(v)
+			b = b[l:]
+		case protowire.Fixed64Type:
+			v, l := protowire.ConsumeFixed64(b)
+			f[n] = v
+			b = b[l:]
+		case protowire.VarintType:
+			v, l := protowire.ConsumeVarint(b)
+			f[n] = int64(v)
+			b = b[l:]
+		}
+	}
+
+	return f
+}
+
+func newRemoteWriteDB(srv *httptest.Server, retries int, opts ...snatch.Option) snatch.DB {
+	return snatch.NewRemoteWriteDB(snatch.RemoteWriteOpts{
+		URL:     srv.URL + "/api/v1/push",
+		Headers: map[string]string{"X-Scope-OrgID": "tenant"},
+		Retries: retries,
+		Backoff: time.Millisecond,
+	}, opts...)
+}
+
+// countBucket returns a Count Bucket of the value at the time in seconds.
+func countBucket(sec int64, v float64) *snatch.Bucket {
+	bkt := &snatch.Bucket{
+		ID: &snatch.ID{Time: time.Unix(sec, 0), Name: "req", Tags: []string{"host", "a"}, Type: snatch.Count, Res: 10 * time.Second},
+	}
+	bkt.Append(v)
+
+	return bkt
+}
+
+func TestRemoteWriteDB_Insert(t *testing.T) {
+	srv, reqs, _ := serveRemoteWrite(t)
+	db := newRemoteWriteDB(srv, 0)
+	defer db.Close()
+	bkts := []*snatch.Bucket{
+		countBucket(10, 3),
+		{
+			ID:   &snatch.ID{Time: time.Unix(10, 0), Name: "mem", Type: snatch.Sample, Res: 10 * time.Second},
+			Vals: []float64{1, 2},
+			Sum:  3,
+		},
+		{
+			ID:    &snatch.ID{Time: time.Unix(20, 0), Name: "latency", Type: snatch.Measure, Res: 10 * time.Second},
+			Units: "ms",
+			Vals:  []float64{1, 2, 3, 4},
+			Sum:   10,
+		},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	got := <-reqs
+	sort.Strings(got)
+	assert.Equal(t, []string{
+		`{__name__="latency_ms",quantile="0.9"} 4@20000`,
+		`{__name__="latency_ms",quantile="0.95"} 4@20000`,
+		`{__name__="latency_ms",quantile="0.97"} 4@20000`,
+		`{__name__="latency_ms",quantile="0.99"} 4@20000`,
+		`{__name__="latency_ms_count"} 4@20000`,
+		`{__name__="latency_ms_sum"} 10@20000`,
+		`{__name__="mem"} 2@10000`,
+		`{__name__="req_total",host="a"} 3@10000`,
+	}, got)
+}
+
+func TestRemoteWriteDB_InsertGroupsSamples(t *testing.T) {
+	srv, reqs, _ := serveRemoteWrite(t)
+	db := newRemoteWriteDB(srv, 0)
+	bkts := []*snatch.Bucket{
+		{ID: &snatch.ID{Time: time.Unix(20, 0), Name: "test", Tags: []string{"__name__", "x", "a.b", "1"}, Type: snatch.Count}, Vals: []float64{1}, Sum: 1},
+		{ID: &snatch.ID{Time: time.Unix(10, 0), Name: "test", Tags: []string{"__name__", "x", "a.b", "1"}, Type: snatch.Count}, Vals: []float64{2}, Sum: 2},
+	}
+
+	err := db.Insert(bkts)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`{__name__="test_total",a_b="1",tag__name__="x"} 2@10000`,
+		`{__name__="test_total",a_b="1",tag__name__="x"} 3@20000`,
+	}, <-reqs)
+}
+
+func TestRemoteWriteDB_InsertAccumulatesCounters(t *testing.T) {
+	srv, reqs, _ := serveRemoteWrite(t)
+	db := newRemoteWriteDB(srv, 0)
+
+	err := db.Insert([]*snatch.Bucket{countBucket(10, 3)})
+	assert.NoError(t, err)
+	err = db.Insert([]*snatch.Bucket{countBucket(20, 4)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{__name__="req_total",host="a"} 3@10000`}, <-reqs)
+	assert.Equal(t, []string{`{__name__="req_total",host="a"} 7@20000`}, <-reqs)
+}
+
+func TestRemoteWriteDB_InsertSkipsMergedBuckets(t *testing.T) {
+	srv, reqs, calls := serveRemoteWrite(t)
+	db := newRemoteWriteDB(srv, 0)
+	first, merged := lateMergeScans(t)
+	next := &snatch.Bucket{
+		ID:   &snatch.ID{Time: first[0].ID.Time.Add(time.Second), Name: "test", Tags: []string{"source", "web"}, Type: snatch.Count, Res: time.Second},
+		Vals: []float64{1},
+		Sum:  1,
+	}
+
+	err := db.Insert(first)
+	assert.NoError(t, err)
+	err = db.Insert(merged)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+	err = db.Insert([]*snatch.Bucket{next})
+
+	assert.NoError(t, err)
+	<-reqs
+	got := <-reqs
+	assert.Len(t, got, 1)
+	assert.True(t, strings.HasPrefix(got[0], `{__name__="test_total",source="web"} 2@`), got[0])
+}
+
+func TestRemoteWriteDB_InsertRetriesServerErrors(t *testing.T) {
+	srv, _, calls := serveRemoteWrite(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	// A Clock driven by the data must not stall the retries.
+	db := newRemoteWriteDB(srv, 2, snatch.WithClock(snatch.NewFakeClock(time.Unix(0, 0))))
+
+	err := db.Insert([]*snatch.Bucket{countBucket(10, 1)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestRemoteWriteDB_InsertStopsRetrying(t *testing.T) {
+	srv, _, calls := serveRemoteWrite(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	db := newRemoteWriteDB(srv, 1)
+
+	err := db.Insert([]*snatch.Bucket{countBucket(10, 1)})
+
+	assert.EqualError(t, err, "snatch: remote write failed: 503 Service Unavailable: ")
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
+
+func TestRemoteWriteDB_InsertDoesNotRetryClientErrors(t *testing.T) {
+	srv, _, calls := serveRemoteWrite(t, http.StatusBadRequest)
+	db := newRemoteWriteDB(srv, 3)
+
+	err := db.Insert([]*snatch.Bucket{countBucket(10, 1)})
+
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
@@ -0,0 +1,159 @@
+package snatch
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+)
+
+// ReplayProgress represents the progress of a replay.
+type ReplayProgress struct {
+	// Lines is the number of lines read.
+	Lines int64
+	// Rejected is the number of lines that could not be parsed,
+	// including lines without a time.
+	Rejected int64
+	// Late is the number of Buckets dropped for arriving after their
+	// interval was written, if the Store reports it.
+	Late int64
+	// Time is the event time reached.
+	Time time.Time
+}
+
+// ReplayOpts configures the replaying of historical lines.
+type ReplayOpts struct {
+	// InvalidLine is called with each line that cannot be parsed.
+	InvalidLine func([]byte)
+	// Progress is called after each scan.
+	Progress func(ReplayProgress)
+}
+
+// Replay parses historical lines from the Reader, inserting complete
+// Buckets into the database as the time of the lines passes the end of
+// their interval, rather than waiting for the wall clock. Lines without
+// a time are rejected.
+//
+// The remaining Buckets are not written, so several Readers can be
+// replayed in time order. Call Flush once the last Reader is replayed.
+//
+// The Application must be created with WithEventTime and a FakeClock,
+// which Replay moves forward to the latest time of the lines. Lines are
+// never dropped, the Reader is read as fast as the database allows, but
+// data older than the intervals already written is late.
+func (a *Application) Replay(r io.Reader, opts ReplayOpts) error {
+	clock, ok := a.clock.(*FakeClock)
+	if !ok {
+		return errors.New("snatch: replay requires a FakeClock")
+	}
+
+	if opts.InvalidLine == nil {
+		opts.InvalidLine = func([]byte) {}
+	}
+	if opts.Progress == nil {
+		opts.Progress = func(ReplayProgress) {}
+	}
+
+	late := a.lateBuckets()
+
+	var prog ReplayProgress
+	var next time.Time
+	rd := bufio.NewReader(r)
+	for {
+		b, err := rd.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Keep reading the rest of a long line.
+			line := append([]byte(nil), b...)
+			for err == bufio.ErrBufferFull {
+				b, err = rd.ReadSlice('\n')
+				line = append(line, b...)
+			}
+			b = line
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(b) > 0 {
+			prog.Lines++
+			if ts, ok := a.replayLine(b, opts.InvalidLine); ok {
+				if ts.After(clock.Now()) {
+					clock.Set(ts)
+				}
+			} else {
+				prog.Rejected++
+			}
+		}
+
+		// Scan once the time of the lines passes the scan time.
+		now := clock.Now()
+		if next.IsZero() {
+			next = a.nextScan(now)
+		}
+		if !now.Before(next) {
+			if err := a.Scan(); err != nil {
+				return err
+			}
+			next = a.nextScan(now)
+
+			prog.Time = now
+			prog.Late = a.lateBuckets() - late
+			opts.Progress(prog)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	prog.Time = clock.Now()
+	prog.Late = a.lateBuckets() - late
+	opts.Progress(prog)
+
+	return nil
+}
+
+// lateBuckets returns the number of late Buckets dropped by the Store,
+// if it reports it.
+func (a *Application) lateBuckets() int64 {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	if sr, ok := a.s.(StatsReporter); ok {
+		return sr.Stats().Late
+	}
+
+	return 0
+}
+
+// replayLine parses the line into the Store, returning the latest
+// time of its Buckets.
+func (a *Application) replayLine(b []byte, errFn func([]byte)) (time.Time, bool) {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	a.stats.read(1, false)
+
+	bkts, err := a.p.parse(b, true)
+	if err != nil || len(bkts) == 0 {
+		reason := ReasonNoMetrics
+		if err != nil {
+			reason = ParseErrorReason(err)
+		}
+		a.stats.parsed(0, map[string]int64{reason: 1})
+
+		errFn(b)
+		return time.Time{}, false
+	}
+	a.stats.parsed(1, nil)
+
+	var ts time.Time
+	for _, bkt := range bkts {
+		if bkt.ID.Time.After(ts) {
+			ts = bkt.ID.Time
+		}
+	}
+	_ = a.s.Add(bkts...)
+
+	return ts, true
+}
@@ -0,0 +1,125 @@
+package snatch_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestApplication_Replay(t *testing.T) {
+	b := []byte(`t=2018-10-10T10:10:01Z count#test=1
+t=2018-10-10T10:10:05Z count#test=2
+invalid
+count#test=10
+t=2018-10-10T10:10:12Z count#test=3
+t=2018-10-10T10:10:25Z count#test=4
+t=2018-10-10T10:10:26Z count#test=5`)
+
+	clock := snatch.NewFakeClock(time.Time{})
+	var inserted [][]*snatch.Bucket
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Run(func(args mock.Arguments) {
+		inserted = append(inserted, args.Get(0).([]*snatch.Bucket))
+	}).Return(nil)
+	opts := []snatch.Option{snatch.WithEventTime(), snatch.WithClock(clock)}
+	s := snatch.NewStore(10*time.Second, opts...)
+	app := snatch.NewApplication(10*time.Second, db, s, opts...)
+
+	var invalid []byte
+	var progress []snatch.ReplayProgress
+	err := app.Replay(bytes.NewReader(b), snatch.ReplayOpts{
+		InvalidLine: func(b []byte) {
+			invalid = append(invalid, b...)
+		},
+		Progress: func(p snatch.ReplayProgress) {
+			progress = append(progress, p)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, inserted, 1)
+	err = app.Flush()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("invalid\ncount#test=10\n"), invalid)
+	start := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	if assert.Len(t, inserted, 2) {
+		// The first interval is scanned once the lines pass it.
+		if assert.Len(t, inserted[0], 1) {
+			assert.True(t, start.Equal(inserted[0][0].ID.Time))
+			assert.Equal(t, float64(3), inserted[0][0].Sum)
+		}
+		// The remaining intervals are flushed.
+		if assert.Len(t, inserted[1], 2) {
+			assert.True(t, start.Add(10*time.Second).Equal(inserted[1][0].ID.Time))
+			assert.Equal(t, float64(3), inserted[1][0].Sum)
+			assert.True(t, start.Add(20*time.Second).Equal(inserted[1][1].ID.Time))
+			assert.Equal(t, float64(9), inserted[1][1].Sum)
+		}
+	}
+	if assert.NotEmpty(t, progress) {
+		last := progress[len(progress)-1]
+		assert.Equal(t, int64(7), last.Lines)
+		assert.Equal(t, int64(2), last.Rejected)
+		assert.True(t, start.Add(20*time.Second).Equal(last.Time))
+	}
+	assert.Equal(t, int64(5), app.Stats().LinesParsed)
+}
+
+func TestApplication_ReplaySpansReaders(t *testing.T) {
+	clock := snatch.NewFakeClock(time.Time{})
+	var inserted []*snatch.Bucket
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Run(func(args mock.Arguments) {
+		inserted = append(inserted, args.Get(0).([]*snatch.Bucket)...)
+	}).Return(nil)
+	opts := []snatch.Option{snatch.WithEventTime(), snatch.WithClock(clock)}
+	s := snatch.NewStore(10*time.Second, opts...)
+	app := snatch.NewApplication(10*time.Second, db, s, opts...)
+
+	err := app.Replay(bytes.NewReader([]byte("t=2018-10-10T10:10:01Z count#test=3\n")), snatch.ReplayOpts{})
+	assert.NoError(t, err)
+	err = app.Replay(bytes.NewReader([]byte("t=2018-10-10T10:10:02Z count#test=4\n")), snatch.ReplayOpts{})
+	assert.NoError(t, err)
+	err = app.Flush()
+
+	assert.NoError(t, err)
+	if assert.Len(t, inserted, 1) {
+		assert.Equal(t, float64(7), inserted[0].Sum)
+	}
+}
+
+func TestApplication_ReplayReportsLateBuckets(t *testing.T) {
+	clock := snatch.NewFakeClock(time.Time{})
+	db := new(mockDB)
+	db.On("Insert", mock.Anything).Return(nil)
+	opts := []snatch.Option{snatch.WithEventTime(), snatch.WithClock(clock)}
+	s := snatch.NewStore(10*time.Second, opts...)
+	app := snatch.NewApplication(10*time.Second, db, s, opts...)
+
+	b := []byte("t=2018-10-10T10:11:00Z count#test=1\nt=2018-10-10T10:11:20Z count#test=1\n")
+	err := app.Replay(bytes.NewReader(b), snatch.ReplayOpts{})
+	assert.NoError(t, err)
+	var progress []snatch.ReplayProgress
+	err = app.Replay(bytes.NewReader([]byte("t=2018-10-10T10:10:00Z count#test=1\n")), snatch.ReplayOpts{
+		Progress: func(p snatch.ReplayProgress) {
+			progress = append(progress, p)
+		},
+	})
+
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, progress) {
+		assert.Equal(t, int64(1), progress[len(progress)-1].Late)
+	}
+}
+
+func TestApplication_ReplayRequiresFakeClock(t *testing.T) {
+	app := snatch.NewApplication(10*time.Second, new(mockDB), new(mockStore))
+
+	err := app.Replay(bytes.NewReader(nil), snatch.ReplayOpts{})
+
+	assert.Error(t, err)
+}
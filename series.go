@@ -0,0 +1,148 @@
+package snatch
+
+import (
+	"sort"
+	"time"
+)
+
+// series tracks the state of a recently active series.
+type series struct {
+	id ID
+	// units is the units of the series.
+	units string
+	// seen is the start of the last interval with data.
+	seen time.Time
+	// next is the start of the next interval to be emitted.
+	next time.Time
+	// last is the last sample value of the series.
+	last float64
+}
+
+// fillable determines if the ID can be gap filled.
+func fillable(id *ID) bool {
+	return !id.Late && (id.Type == Count || id.Type == Sample)
+}
+
+// fill emits gap filling Buckets for the tracked series up to the
+// watermark, given the Buckets emitted in the current scan.
+func (s *memStore) fill(emitted []*Bucket) []*Bucket {
+	observed := map[string][]*Bucket{}
+	for _, bkt := range emitted {
+		if !fillable(bkt.ID) {
+			continue
+		}
+
+		_, key := bkt.ID.Keys()
+		observed[key] = append(observed[key], bkt)
+
+		if _, ok := s.series[key]; !ok {
+			s.series[key] = &series{id: *bkt.ID, units: bkt.Units, next: bkt.ID.Time}
+		}
+	}
+
+	var out []*Bucket
+	for key, ser := range s.series {
+		res := s.resOf(&ser.id)
+
+		bkts := observed[key]
+		sort.SliceStable(bkts, func(i, j int) bool {
+			return bkts[i].ID.Time.Before(bkts[j].ID.Time)
+		})
+
+		for _, bkt := range bkts {
+			out = s.fillTo(out, ser, res, bkt.ID.Time)
+
+			if !bkt.ID.Time.Before(ser.seen) {
+				ser.seen = bkt.ID.Time
+				if len(bkt.Vals) > 0 {
+					ser.last = bkt.Vals[len(bkt.Vals)-1]
+				}
+			}
+			if next := bkt.ID.Time.Add(res); next.After(ser.next) {
+				ser.next = next
+			}
+		}
+		out = s.fillTo(out, ser, res, s.watermark)
+
+		if s.watermark.Sub(ser.seen) > time.Duration(s.fillTTL+1)*res {
+			delete(s.series, key)
+		}
+	}
+
+	return out
+}
+
+// fillTo emits gap filling Buckets for the series for the intervals
+// before the given time that are complete and within the TTL.
+func (s *memStore) fillTo(out []*Bucket, ser *series, res time.Duration, until time.Time) []*Bucket {
+	ttl := time.Duration(s.fillTTL) * res
+
+	for ; ser.next.Before(until); ser.next = ser.next.Add(res) {
+		if ser.next.Add(res).After(s.watermark) || ser.next.Sub(ser.seen) > ttl {
+			break
+		}
+
+		id := ser.id
+		id.Time = ser.next
+
+		bkt := &Bucket{ID: &id, Units: ser.units}
+		switch id.Type {
+		case Count:
+			bkt.Append(0)
+
+		case Sample:
+			bkt.Append(ser.last)
+		}
+
+		out = append(out, bkt)
+	}
+
+	return out
+}
+
+// gaugeTTL is how long the current value of a Sample series is
+// kept without updates.
+const gaugeTTL = time.Hour
+
+// gauge tracks the current value of a Sample series.
+type gauge struct {
+	val  float64
+	seen time.Time
+}
+
+// resolveGauge converts relative Sample values into absolute values,
+// tracking the current value of the series.
+func (s *memStore) resolveGauge(bkt *Bucket) {
+	_, key := bkt.ID.Keys()
+
+	g, ok := s.gauges[key]
+	if !ok {
+		g = &gauge{}
+		s.gauges[key] = g
+	}
+	g.seen = s.clock.Now()
+
+	if !bkt.Relative {
+		if len(bkt.Vals) > 0 {
+			g.val = bkt.Vals[len(bkt.Vals)-1]
+		}
+		return
+	}
+
+	vals := bkt.Vals
+	bkt.Vals, bkt.Sum, bkt.Relative = nil, 0, false
+	for _, v := range vals {
+		g.val += v
+		bkt.Append(g.val)
+	}
+}
+
+// expireGauges removes the current values of inactive Sample series.
+func (s *memStore) expireGauges() {
+	expiry := s.clock.Now().Add(-1 * gaugeTTL)
+	for key, g := range s.gauges {
+		if g.seen.Before(expiry) {
+			delete(s.gauges, key)
+		}
+	}
+}
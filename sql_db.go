@@ -0,0 +1,209 @@
+package snatch
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLColumns names the columns of a SQL table.
+type SQLColumns struct {
+	Time   string
+	Name   string
+	Type   string
+	Tags   string
+	Fields string
+}
+
+// SQLOpts configures the writing of metrics to a SQL table.
+type SQLOpts struct {
+	// Table is the table the metrics are written to, "metrics" by default.
+	Table string
+	// Columns names the columns, named after their field by default.
+	Columns SQLColumns
+	// BatchSize is the maximum number of rows per insert, 500 by default.
+	BatchSize int
+}
+
+type sqlDB struct {
+	db        *sql.DB
+	table     string
+	index     string
+	cols      SQLColumns
+	batchSize int
+
+	rollups map[time.Duration]Rollup
+	agg     SampleAgg
+}
+
+// NewSQLDB creates a DB that writes Buckets to a Postgres compatible
+// database, such as TimescaleDB, creating the table if it does not exist.
+//
+// Each Bucket is written as a row with its tags and fields as JSONB
+// objects. The Buckets of an Insert are written in a single transaction,
+// with multi-row inserts. Rows are unique by time, name, type and tags,
+// so Merged Buckets replace the fields of the row written before.
+func NewSQLDB(db *sql.DB, sopts SQLOpts, opts ...Option) (DB, error) {
+	o := newOptions(opts)
+
+	if sopts.Table == "" {
+		sopts.Table = "metrics"
+	}
+	cols := sopts.Columns
+	setDefault(&cols.Time, "time")
+	setDefault(&cols.Name, "name")
+	setDefault(&cols.Type, "type")
+	setDefault(&cols.Tags, "tags")
+	setDefault(&cols.Fields, "fields")
+	if sopts.BatchSize <= 0 {
+		sopts.BatchSize = 500
+	}
+
+	parts := strings.Split(sopts.Table, ".")
+	s := &sqlDB{
+		db:        db,
+		table:     quoteIdent(sopts.Table),
+		index:     quoteIdent(parts[len(parts)-1] + "_series_key"),
+		cols:      cols,
+		batchSize: sopts.BatchSize,
+		rollups:   rollupsByRes(o.rollups),
+		agg:       o.sampleAgg,
+	}
+
+	if err := s.createTable(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// createTable creates the table and its unique index if they do not exist.
+func (s *sqlDB) createTable() error {
+	q := "CREATE TABLE IF NOT EXISTS " + s.table + " (" +
+		quoteIdent(s.cols.Time) + " TIMESTAMPTZ NOT NULL, " +
+		quoteIdent(s.cols.Name) + " TEXT NOT NULL, " +
+		quoteIdent(s.cols.Type) + " TEXT NOT NULL, " +
+		quoteIdent(s.cols.Tags) + " JSONB NOT NULL, " +
+		quoteIdent(s.cols.Fields) + " JSONB NOT NULL)"
+	if _, err := s.db.Exec(q); err != nil {
+		return err
+	}
+
+	q = "CREATE UNIQUE INDEX IF NOT EXISTS " + s.index + " ON " + s.table + " (" + s.key() + ")"
+	_, err := s.db.Exec(q)
+	return err
+}
+
+// key returns the columns a row is unique by.
+func (s *sqlDB) key() string {
+	return quoteIdent(s.cols.Time) + ", " +
+		quoteIdent(s.cols.Name) + ", " +
+		quoteIdent(s.cols.Type) + ", " +
+		quoteIdent(s.cols.Tags)
+}
+
+// Insert writes the Buckets to the table.
+func (s *sqlDB) Insert(bkts []*Bucket) error {
+	if len(bkts) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	bkts = sortBuckets(bkts)
+	for len(bkts) > 0 {
+		n := s.batchSize
+		if n > len(bkts) {
+			n = len(bkts)
+		}
+
+		if err := s.insert(tx, bkts[:n]); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		bkts = bkts[n:]
+	}
+
+	return tx.Commit()
+}
+
+// insert writes the Buckets in a single multi-row insert, replacing
+// the fields of existing rows.
+func (s *sqlDB) insert(tx *sql.Tx, bkts []*Bucket) error {
+	var q strings.Builder
+	q.WriteString("INSERT INTO " + s.table + " (" +
+		quoteIdent(s.cols.Time) + ", " +
+		quoteIdent(s.cols.Name) + ", " +
+		quoteIdent(s.cols.Type) + ", " +
+		quoteIdent(s.cols.Tags) + ", " +
+		quoteIdent(s.cols.Fields) + ") VALUES ")
+
+	args := make([]interface{}, 0, 5*len(bkts))
+	for i, bkt := range bkts {
+		tags, err := json.Marshal(tagMap(bkt.ID.Tags))
+		if err != nil {
+			return err
+		}
+		fields, err := json.Marshal(bkt.Fields(s.agg))
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		q.WriteString("(")
+		for j := 1; j <= 5; j++ {
+			if j > 1 {
+				q.WriteString(", ")
+			}
+			q.WriteString("$" + strconv.Itoa(len(args)+j))
+		}
+		q.WriteString(")")
+
+		args = append(args,
+			bkt.ID.Time.UTC(),
+			pointName(bkt, rollupOf(s.rollups, bkt)),
+			string(bkt.ID.Type),
+			string(tags),
+			string(fields),
+		)
+	}
+
+	q.WriteString(" ON CONFLICT (" + s.key() + ") DO UPDATE SET " +
+		quoteIdent(s.cols.Fields) + " = EXCLUDED." + quoteIdent(s.cols.Fields))
+
+	_, err := tx.Exec(q.String(), args...)
+	return err
+}
+
+// Ping checks that the database is reachable.
+func (s *sqlDB) Ping() error {
+	return s.db.Ping()
+}
+
+// Close closes the database.
+func (s *sqlDB) Close() error {
+	return s.db.Close()
+}
+
+// quoteIdent quotes a possibly schema qualified identifier.
+func quoteIdent(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.Replace(p, `"`, `""`, -1) + `"`
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func setDefault(s *string, v string) {
+	if *s == "" {
+		*s = v
+	}
+}
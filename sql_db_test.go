@@ -0,0 +1,222 @@
+package snatch_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDriver is a SQL driver that records the executed statements.
+// Only the SQL text and arguments are checked, the statements are not
+// run against Postgres.
+type recordingDriver struct {
+	mu    sync.Mutex
+	execs map[string][]recordedExec
+}
+
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+var testDriver = &recordingDriver{execs: map[string][]recordedExec{}}
+
+func init() {
+	sql.Register("snatch-test", testDriver)
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{d: d, name: name}, nil
+}
+
+func (d *recordingDriver) record(name, query string, args []driver.Value) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.execs[name] = append(d.execs[name], recordedExec{query: query, args: args})
+}
+
+func (d *recordingDriver) recorded(name string) []recordedExec {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.execs[name]
+}
+
+type recordingConn struct {
+	d    *recordingDriver
+	name string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	c.d.record(c.name, "BEGIN", nil)
+	return c, nil
+}
+
+func (c *recordingConn) Commit() error {
+	c.d.record(c.name, "COMMIT", nil)
+	return nil
+}
+
+func (c *recordingConn) Rollback() error {
+	c.d.record(c.name, "ROLLBACK", nil)
+	return nil
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	vals := make([]driver.Value, len(args))
+	for i, a := range args {
+		vals[i] = a.Value
+	}
+	c.d.record(c.name, query, vals)
+
+	if strings.HasPrefix(query, "INSERT") && strings.HasSuffix(c.name, "?fail") {
+		return nil, errors.New("test error")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+
+	name := t.Name()
+	db, err := sql.Open("snatch-test", name)
+	assert.NoError(t, err)
+
+	return db, name
+}
+
+func TestNewSQLDB_CreatesTable(t *testing.T) {
+	db, name := openTestDB(t)
+
+	_, err := snatch.NewSQLDB(db, snatch.SQLOpts{
+		Table:   "public.my metrics",
+		Columns: snatch.SQLColumns{Time: "ts", Fields: "vals"},
+	})
+
+	assert.NoError(t, err)
+	execs := testDriver.recorded(name)
+	assert.Len(t, execs, 2)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "public"."my metrics" ("ts" TIMESTAMPTZ NOT NULL, "name" TEXT NOT NULL, "type" TEXT NOT NULL, "tags" JSONB NOT NULL, "vals" JSONB NOT NULL)`, execs[0].query)
+	assert.Equal(t, `CREATE UNIQUE INDEX IF NOT EXISTS "my metrics_series_key" ON "public"."my metrics" ("ts", "name", "type", "tags")`, execs[1].query)
+}
+
+// sqlBucket returns a Count Bucket of the value at the time in seconds.
+func sqlBucket(name string, sec int64, v float64) *snatch.Bucket {
+	return &snatch.Bucket{
+		ID:   &snatch.ID{Time: time.Unix(sec, 0), Name: name, Type: snatch.Count, Res: 10 * time.Second},
+		Vals: []float64{v},
+		Sum:  v,
+	}
+}
+
+func TestSQLDB_Insert(t *testing.T) {
+	db, name := openTestDB(t)
+	r, _ := snatch.ParseRollup("1m")
+	s, _ := snatch.NewSQLDB(db, snatch.SQLOpts{
+		Table:   "app_metrics",
+		Columns: snatch.SQLColumns{Time: "ts", Name: "metric", Type: "kind", Tags: "labels", Fields: "vals"},
+	}, snatch.WithRollups(r))
+	loc := time.FixedZone("CEST", 2*60*60)
+	bkts := []*snatch.Bucket{
+		{
+			ID:   &snatch.ID{Time: time.Date(2018, 10, 10, 12, 10, 0, 0, loc), Name: "foo.bar", Tags: []string{"path", `/a "b"`}, Type: snatch.Sample, Res: time.Minute, Rollup: true, Late: true},
+			Vals: []float64{1.5},
+			Sum:  1.5,
+		},
+	}
+
+	err := s.Insert(bkts)
+
+	assert.NoError(t, err)
+	execs := testDriver.recorded(name)
+	assert.Len(t, execs, 5)
+	assert.Equal(t, `INSERT INTO "app_metrics" ("ts", "metric", "kind", "labels", "vals") VALUES ($1, $2, $3, $4, $5)`+
+		` ON CONFLICT ("ts", "metric", "kind", "labels") DO UPDATE SET "vals" = EXCLUDED."vals"`, execs[3].query)
+	assert.Equal(t, []driver.Value{
+		time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC), "foo_bar_1m0s_late", "sample", `{"path":"/a \"b\""}`, `{"value":1.5}`,
+	}, execs[3].args)
+}
+
+func TestSQLDB_InsertBatchesInOneTransaction(t *testing.T) {
+	db, name := openTestDB(t)
+	s, _ := snatch.NewSQLDB(db, snatch.SQLOpts{BatchSize: 2})
+	bkts := []*snatch.Bucket{
+		sqlBucket("e", 50, 5),
+		sqlBucket("d", 40, 4),
+		sqlBucket("c", 30, 3),
+		sqlBucket("b", 20, 2),
+		sqlBucket("a", 10, 1),
+	}
+
+	err := s.Insert(bkts)
+
+	assert.NoError(t, err)
+	execs := testDriver.recorded(name)
+	assert.Len(t, execs, 7)
+	assert.Equal(t, "BEGIN", execs[2].query)
+	assert.True(t, strings.HasPrefix(execs[3].query, `INSERT INTO "metrics" ("time", "name", "type", "tags", "fields") VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10) ON CONFLICT`))
+	assert.Equal(t, []interface{}{"a", "b"}, []interface{}{execs[3].args[1], execs[3].args[6]})
+	assert.Equal(t, []interface{}{"c", "d"}, []interface{}{execs[4].args[1], execs[4].args[6]})
+	assert.True(t, strings.HasPrefix(execs[5].query, `INSERT INTO "metrics" ("time", "name", "type", "tags", "fields") VALUES ($1, $2, $3, $4, $5) ON CONFLICT`))
+	assert.Equal(t, "e", execs[5].args[1])
+	assert.Equal(t, "COMMIT", execs[6].query)
+}
+
+func TestSQLDB_InsertReplacesMergedBuckets(t *testing.T) {
+	db, name := openTestDB(t)
+	s, _ := snatch.NewSQLDB(db, snatch.SQLOpts{})
+	first, merged := lateMergeScans(t)
+
+	err := s.Insert(first)
+	assert.NoError(t, err)
+	err = s.Insert(merged)
+
+	assert.NoError(t, err)
+	execs := testDriver.recorded(name)
+	assert.Len(t, execs, 8)
+	assert.Equal(t, execs[3].args[:4], execs[6].args[:4])
+	assert.Equal(t, `{"value":1}`, execs[3].args[4])
+	assert.Equal(t, `{"value":3}`, execs[6].args[4])
+	assert.True(t, strings.HasSuffix(execs[6].query, ` ON CONFLICT ("time", "name", "type", "tags") DO UPDATE SET "fields" = EXCLUDED."fields"`))
+}
+
+func TestSQLDB_InsertSkipsEmpty(t *testing.T) {
+	db, name := openTestDB(t)
+	s, _ := snatch.NewSQLDB(db, snatch.SQLOpts{})
+
+	err := s.Insert(nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, testDriver.recorded(name), 2)
+}
+
+func TestSQLDB_InsertRollsBackOnError(t *testing.T) {
+	name := t.Name() + "?fail"
+	db, _ := sql.Open("snatch-test", name)
+	s, _ := snatch.NewSQLDB(db, snatch.SQLOpts{BatchSize: 2})
+	bkts := []*snatch.Bucket{sqlBucket("a", 10, 1), sqlBucket("b", 20, 2), sqlBucket("c", 30, 3)}
+
+	err := s.Insert(bkts)
+
+	assert.EqualError(t, err, "test error")
+	execs := testDriver.recorded(name)
+	assert.Len(t, execs, 5)
+	assert.Equal(t, "ROLLBACK", execs[4].query)
+}
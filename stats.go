@@ -0,0 +1,212 @@
+package snatch
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WithSelfMetrics configures the Application to write its own metrics
+// to the database, with the names prefixed by the given prefix.
+func WithSelfMetrics(prefix string) Option {
+	return func(o *options) {
+		o.selfMetrics = true
+		o.selfPrefix = prefix
+	}
+}
+
+// Stats represents the state of an Application.
+type Stats struct {
+	// LinesRead is the number of lines read.
+	LinesRead int64
+	// LinesParsed is the number of lines parsed into metrics.
+	LinesParsed int64
+	// ParseErrors is the number of rejected lines by reason.
+	ParseErrors map[string]int64
+	// DroppedBatches is the number of batches dropped due to a full queue.
+	DroppedBatches int64
+	// Series is the number of Buckets written by the last insert.
+	Series int
+	// Inserts is the number of inserts into the database.
+	Inserts int64
+	// InsertFailures is the number of failed inserts into the database.
+	InsertFailures int64
+	// InsertLatency is the duration of the last insert.
+	InsertLatency time.Duration
+	// Store is the state of the Store, if it reports it.
+	Store StoreStats
+}
+
+// clone returns a deep copy of the Stats.
+func (s Stats) clone() Stats {
+	errs := make(map[string]int64, len(s.ParseErrors))
+	for reason, n := range s.ParseErrors {
+		errs[reason] = n
+	}
+	s.ParseErrors = errs
+
+	return s
+}
+
+// stats tracks the state of an Application.
+type stats struct {
+	mu sync.Mutex
+	s  Stats
+	// reported is the state last written to the database,
+	// for the interval starting at reportedAt.
+	reported   Stats
+	reportedAt time.Time
+}
+
+func newStats() *stats {
+	return &stats{
+		s:        Stats{ParseErrors: map[string]int64{}},
+		reported: Stats{ParseErrors: map[string]int64{}},
+	}
+}
+
+// read records a batch of lines that was read.
+func (s *stats) read(lines int64, dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.LinesRead += lines
+	if dropped {
+		s.s.DroppedBatches++
+	}
+}
+
+// parsed records the outcome of parsing a batch.
+func (s *stats) parsed(lines int64, errs map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.LinesParsed += lines
+	for reason, n := range errs {
+		s.s.ParseErrors[reason] += n
+	}
+}
+
+// inserted records an insert into the database.
+func (s *stats) inserted(series int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.Inserts++
+	s.s.InsertLatency = latency
+	if err != nil {
+		s.s.InsertFailures++
+		return
+	}
+	s.s.Series = series
+}
+
+// lastReported returns the state last written to the database and
+// the interval it was written for.
+func (s *stats) lastReported() (Stats, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reported, s.reportedAt
+}
+
+// report records the state written to the database for the interval.
+func (s *stats) report(cur Stats, ts time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reported = cur
+	s.reportedAt = ts
+}
+
+// snapshot returns a copy of the current state.
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s.clone()
+}
+
+// countLines counts the lines in the buffer, including a final
+// line without a line ending.
+func countLines(b []byte) int64 {
+	n := int64(bytes.Count(b, []byte{'\n'}))
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		n++
+	}
+
+	return n
+}
+
+// Stats returns the state of the Application.
+func (a *Application) Stats() Stats {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+
+	return a.currentStats()
+}
+
+func (a *Application) currentStats() Stats {
+	s := a.stats.snapshot()
+	if sr, ok := a.s.(StatsReporter); ok {
+		s.Store = sr.Stats()
+	}
+
+	return s
+}
+
+// selfTime returns the time of the interval the Application's own
+// metrics are reported for, the last complete interval.
+func (a *Application) selfTime() time.Time {
+	return a.clock.Now().Add(-1 * a.lateness).Truncate(a.interval).Add(-1 * a.interval)
+}
+
+// selfBuckets returns the Buckets of the Application's own metrics for
+// the interval, with counters as the change since they were last reported.
+func (a *Application) selfBuckets(ts time.Time, cur, prev Stats) []*Bucket {
+	var bkts []*Bucket
+	add := func(typ Type, name, units string, v float64, tags ...string) {
+		bkt := &Bucket{
+			ID: &ID{
+				Type: typ,
+				Name: a.selfPrefix + name,
+				Tags: tags,
+				Time: ts,
+				Res:  a.interval,
+			},
+			Units: units,
+		}
+		bkt.Append(v)
+		bkts = append(bkts, bkt)
+	}
+
+	add(Count, "lines.read", "", float64(cur.LinesRead-prev.LinesRead))
+	add(Count, "lines.parsed", "", float64(cur.LinesParsed-prev.LinesParsed))
+
+	reasons := make([]string, 0, len(cur.ParseErrors))
+	for reason := range cur.ParseErrors {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		add(Count, "parse.errors", "", float64(cur.ParseErrors[reason]-prev.ParseErrors[reason]), "reason", reason)
+	}
+
+	add(Count, "batches.dropped", "", float64(cur.DroppedBatches-prev.DroppedBatches))
+	add(Sample, "series", "", float64(cur.Series))
+	add(Count, "inserts.failed", "", float64(cur.InsertFailures-prev.InsertFailures))
+	if cur.Inserts > 0 {
+		add(Measure, "insert.latency", "ms", float64(cur.InsertLatency)/float64(time.Millisecond))
+	}
+
+	if _, ok := a.s.(StatsReporter); ok {
+		add(Sample, "store.series", "", float64(cur.Store.Series))
+		add(Sample, "store.bytes", "bytes", float64(cur.Store.Bytes))
+		add(Count, "store.evicted", "", float64(cur.Store.Evicted-prev.Store.Evicted))
+		add(Count, "store.dropped", "", float64(cur.Store.Dropped-prev.Store.Dropped))
+		add(Count, "store.late", "", float64(cur.Store.Late-prev.Store.Late))
+	}
+
+	return bkts
+}
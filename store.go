@@ -1,6 +1,10 @@
 package snatch
 
 import (
+	"bufio"
+	"io"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -14,80 +18,491 @@ type Store interface {
 	Flush() (<-chan *Bucket, error)
 }
 
+// Snapshotter is implemented by Stores that can save and restore
+// their contents.
+type Snapshotter interface {
+	// Snapshot writes the Buckets in the Store to the Writer.
+	Snapshot(io.Writer) error
+	// Restore adds the Buckets from a snapshot into the Store.
+	Restore(io.Reader) error
+}
+
+// Committer is implemented by Stores that need to know when the
+// scanned Buckets have been inserted into the database.
+type Committer interface {
+	// Commit is called after scanned Buckets have been inserted.
+	Commit() error
+}
+
+// SeriesLister is implemented by Stores that can list the series
+// they hold.
+type SeriesLister interface {
+	// Series returns the IDs of the Buckets held.
+	Series() []ID
+}
+
 type memStore struct {
-	res time.Duration
+	res      time.Duration
+	rollups  []time.Duration
+	lateness time.Duration
+	policy   LatePolicy
+	horizon  time.Duration
+	fillTTL  int
+	budget   Budget
+	clock    Clock
 
-	store map[int64]map[string]*Bucket
+	mu        sync.Mutex
+	store     map[int64]map[string]*Bucket
+	closed    map[int64]map[string]*Bucket
+	dirty     map[*Bucket]bool
+	series    map[string]*series
+	gauges    map[string]*gauge
+	ends      endHeap
+	byEnd     map[int64]map[*Bucket]bool
+	early     []*Bucket
+	spilled   bool
+	stats     StoreStats
+	watermark time.Time
 }
 
 // NewStore creates a new in-memory store.
-func NewStore(res time.Duration) Store {
+func NewStore(res time.Duration, opts ...Option) Store {
+	return newMemStore(res, opts...)
+}
+
+func newMemStore(res time.Duration, opts ...Option) *memStore {
+	o := newOptions(opts)
+
+	rollups := make([]time.Duration, 0, len(o.rollups))
+	for _, r := range o.rollups {
+		rollups = append(rollups, r.Res)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i] < rollups[j] })
+
 	return &memStore{
-		res:   res,
-		store: map[int64]map[string]*Bucket{},
+		res:      res,
+		rollups:  rollups,
+		lateness: o.lateness,
+		policy:   o.latePolicy,
+		horizon:  o.lateHorizon,
+		fillTTL:  o.fillTTL,
+		budget:   o.budget,
+		clock:    o.clock,
+		store:    map[int64]map[string]*Bucket{},
+		closed:   map[int64]map[string]*Bucket{},
+		dirty:    map[*Bucket]bool{},
+		series:   map[string]*series{},
+		gauges:   map[string]*gauge{},
+		byEnd:    map[int64]map[*Bucket]bool{},
 	}
 }
 
 // Add adds Buckets into the Store.
 func (s *memStore) Add(bkts ...*Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for _, bkt := range bkts {
-		ts, key := bkt.ID.Keys()
+		s.add(bkt)
+	}
+
+	return nil
+}
+
+func (s *memStore) add(bkt *Bucket) {
+	if bkt.ID.Type == Sample {
+		s.resolveGauge(bkt)
+	}
+
+	if s.isLate(bkt.ID) {
+		s.addLate(bkt)
+		return
+	}
+
+	if !s.admit(bkt) {
+		return
+	}
+
+	s.putStore(bkt)
+	s.enforce()
+}
+
+// isLate determines if the interval of the ID has already been emitted.
+func (s *memStore) isLate(id *ID) bool {
+	if s.watermark.IsZero() || id.Late {
+		return false
+	}
+
+	return !id.Time.Add(s.resOf(id)).After(s.watermark)
+}
+
+func (s *memStore) addLate(bkt *Bucket) {
+	switch s.policy {
+	case LateMerge:
+		res := s.resOf(bkt.ID)
+		if bkt.ID.Time.Add(res).Before(s.watermark.Add(-1 * s.horizon)) {
+			s.stats.Late++
+			return
+		}
+
+		s.dirty[put(s.closed, bkt)] = true
+
+		if r := s.rollupOf(res); r > 0 {
+			s.add(bkt.rollup(r))
+		}
+
+	case LateRoute:
+		bkt.ID.Late = true
+		s.putStore(bkt)
+
+	default:
+		s.stats.Late++
+	}
+}
+
+// buckets returns a copy of the Buckets in the store.
+func (s *memStore) buckets() []*Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bkts []*Bucket
+	for _, box := range s.store {
+		for _, bkt := range box {
+			bkts = append(bkts, bkt.clone())
+		}
+	}
+
+	return bkts
+}
+
+// pending returns a copy of the Buckets that have not been emitted yet,
+// including those flushed early but not yet scanned, and those spilled
+// to disk. Buckets with late data merged into them are part of the state.
+func (s *memStore) pending() ([]*Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bkts, err := s.spilledBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, box := range s.store {
+		for _, bkt := range box {
+			bkts = append(bkts, bkt.clone())
+		}
+	}
+	for _, bkt := range s.early {
+		bkts = append(bkts, bkt.clone())
+	}
 
-		box, ok := s.store[ts]
-		if !ok {
-			s.store[ts] = map[string]*Bucket{
-				key: bkt,
+	return bkts, nil
+}
+
+// state returns the records of the state kept across restarts: the
+// current values of Sample series, and the closed Buckets late data
+// is merged into.
+func (s *memStore) state() []record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recs := make([]record, 0, len(s.gauges))
+	for key, g := range s.gauges {
+		recs = append(recs, record{State: recordGauge, Key: key, Vals: []float64{g.val}})
+	}
+	for _, box := range s.closed {
+		for _, bkt := range box {
+			rec := newRecord(bkt)
+			rec.State = recordClosed
+			if s.dirty[bkt] {
+				rec.State = recordDirty
 			}
-			continue
+			recs = append(recs, rec)
 		}
+	}
+
+	return recs
+}
 
-		if b, ok := box[key]; ok {
-			b.Merge(bkt)
-			continue
+// restoreState restores the state in the record, returning false
+// if the record is a Bucket.
+func (s *memStore) restoreState(rec record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch rec.State {
+	case recordGauge:
+		if len(rec.Vals) > 0 {
+			s.gauges[rec.Key] = &gauge{val: rec.Vals[0], seen: s.clock.Now()}
 		}
+	case recordClosed:
+		put(s.closed, rec.Bucket())
+	case recordDirty:
+		s.dirty[put(s.closed, rec.Bucket())] = true
+	default:
+		return false
+	}
 
-		box[key] = bkt
+	return true
+}
+
+// Series returns the IDs of the Buckets held, ordered by time.
+func (s *memStore) Series() []ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type keyed struct {
+		key string
+		id  ID
 	}
 
-	return nil
+	var ids []keyed
+	for _, box := range s.store {
+		for key, bkt := range box {
+			ids = append(ids, keyed{key: key, id: *bkt.ID})
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if !ids[i].id.Time.Equal(ids[j].id.Time) {
+			return ids[i].id.Time.Before(ids[j].id.Time)
+		}
+		return ids[i].key < ids[j].key
+	})
+
+	out := make([]ID, len(ids))
+	for i, k := range ids {
+		out[i] = k.id
+	}
+
+	return out
+}
+
+// Snapshot writes the Buckets in the Store to the Writer, along with
+// the current values of Sample series and the closed Buckets late data
+// is merged into.
+func (s *memStore) Snapshot(w io.Writer) error {
+	s.mu.Lock()
+	err := s.unspill(func(time.Duration, int64) bool { return true })
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := encodeRecords(bw, s.state()); err != nil {
+		return err
+	}
+	if err := writeRecords(bw, s.buckets()); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Restore adds the Buckets from a snapshot into the Store.
+func (s *memStore) Restore(r io.Reader) error {
+	return s.restore(r, s.Add)
+}
+
+// restore restores the state and adds the Buckets from a snapshot.
+func (s *memStore) restore(r io.Reader, add func(...*Bucket) error) error {
+	_, err := decodeRecords(bufio.NewReader(r), func(rec record) {
+		if !s.restoreState(rec) {
+			_ = add(rec.Bucket())
+		}
+	})
+
+	return err
 }
 
 // Scan scans the store for complete Buckets.
 func (s *memStore) Scan() (<-chan *Bucket, error) {
-	buckets := make(chan *Bucket, 1000)
-	go func(out chan *Bucket) {
-		ready := time.Now().Truncate(s.res).Add(-1 * (s.res + time.Second)).Unix()
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.watermark = now.Add(-1 * s.lateness)
+	ready := func(res time.Duration, ts int64) bool {
+		return !time.Unix(ts, 0).Add(res).After(s.watermark)
+	}
+	if err := s.unspill(ready); err != nil {
+		return nil, err
+	}
+	bkts := s.collect(ready)
+	s.expire()
+	s.expireGauges()
+
+	if s.fillTTL > 0 {
+		bkts = append(bkts, s.fill(bkts)...)
+	}
+
+	return emit(bkts), nil
+}
 
+// Flush flushes all Buckets from the Store.
+func (s *memStore) Flush() (<-chan *Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := func(time.Duration, int64) bool { return true }
+	if err := s.unspill(all); err != nil {
+		return nil, err
+	}
+	bkts := s.collect(all)
+
+	return emit(bkts), nil
+}
+
+// collect removes the Buckets that are ready from the store, finest
+// resolution first, rolling each one up into its next coarser resolution.
+func (s *memStore) collect(ready func(res time.Duration, ts int64) bool) []*Bucket {
+	var out []*Bucket
+	for _, res := range s.resolutions() {
+		var bkts []*Bucket
 		for ts, box := range s.store {
-			if ts >= ready {
+			for key, bkt := range box {
+				if s.resOf(bkt.ID) != res || !ready(res, ts) {
+					continue
+				}
+
+				bkts = append(bkts, bkt)
+				delete(box, key)
+				s.removed(bkt)
+			}
+
+			if len(box) == 0 {
+				delete(s.store, ts)
+			}
+		}
+
+		// Rollups must be merged in time order to keep samples consistent.
+		sort.SliceStable(bkts, func(i, j int) bool {
+			return bkts[i].ID.Time.Before(bkts[j].ID.Time)
+		})
+
+		r := s.rollupOf(res)
+		for _, bkt := range bkts {
+			if bkt.ID.Late {
+				out = append(out, bkt)
 				continue
 			}
 
-			for _, v := range box {
-				out <- v
+			if r > 0 {
+				s.putStore(bkt.rollup(r))
 			}
-			delete(s.store, ts)
+
+			if s.policy == LateMerge {
+				// Keep the Bucket so late data can be merged into it.
+				put(s.closed, bkt)
+				bkt = bkt.clone()
+			}
+
+			out = append(out, bkt)
 		}
+	}
 
-		close(out)
-	}(buckets)
+	out = append(out, s.early...)
+	s.early = nil
+	s.stats.Early = 0
 
-	return buckets, nil
+	for bkt := range s.dirty {
+		merged := bkt.clone()
+		merged.Merged = true
+		out = append(out, merged)
+		delete(s.dirty, bkt)
+	}
+
+	return out
 }
 
-// Flush flushes all Buckets from the Store.
-func (s *memStore) Flush() (<-chan *Bucket, error) {
-	buckets := make(chan *Bucket, 1000)
-	go func(out chan *Bucket) {
-		for ts, box := range s.store {
-			for _, v := range box {
-				out <- v
+// expire removes closed Buckets that are beyond the late horizon.
+func (s *memStore) expire() {
+	horizon := s.watermark.Add(-1 * s.horizon)
+	for ts, box := range s.closed {
+		for key, bkt := range box {
+			if bkt.ID.Time.Add(s.resOf(bkt.ID)).Before(horizon) {
+				delete(box, key)
 			}
-			delete(s.store, ts)
 		}
 
-		close(out)
-	}(buckets)
+		if len(box) == 0 {
+			delete(s.closed, ts)
+		}
+	}
+}
+
+// resolutions returns the resolutions in the store and its rollups
+// in ascending order.
+func (s *memStore) resolutions() []time.Duration {
+	seen := map[time.Duration]bool{}
+	for _, r := range s.rollups {
+		seen[r] = true
+	}
+	for _, box := range s.store {
+		for _, bkt := range box {
+			seen[s.resOf(bkt.ID)] = true
+		}
+	}
+
+	res := make([]time.Duration, 0, len(seen))
+	for r := range seen {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+
+	return res
+}
+
+// resOf returns the resolution of the ID.
+func (s *memStore) resOf(id *ID) time.Duration {
+	if id.Res > 0 {
+		return id.Res
+	}
+
+	return s.res
+}
+
+// rollupOf returns the next coarser resolution for the given resolution,
+// or zero if there is none.
+func (s *memStore) rollupOf(res time.Duration) time.Duration {
+	for _, r := range s.rollups {
+		if r > res && r%res == 0 {
+			return r
+		}
+	}
+
+	return 0
+}
+
+// put adds the Bucket to the box, merging it with an existing Bucket.
+// The Bucket held by the box is returned.
+func put(boxes map[int64]map[string]*Bucket, bkt *Bucket) *Bucket {
+	ts, key := bkt.ID.Keys()
+
+	box, ok := boxes[ts]
+	if !ok {
+		boxes[ts] = map[string]*Bucket{
+			key: bkt,
+		}
+		return bkt
+	}
+
+	if b, ok := box[key]; ok {
+		b.Merge(bkt)
+		return b
+	}
+
+	box[key] = bkt
+	return bkt
+}
+
+func emit(bkts []*Bucket) <-chan *Bucket {
+	out := make(chan *Bucket, len(bkts))
+	for _, bkt := range bkts {
+		out <- bkt
+	}
+	close(out)
 
-	return buckets, nil
+	return out
 }
@@ -1,6 +1,8 @@
 package snatch_test
 
 import (
+	"bytes"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -8,110 +10,139 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+type storeFactory func(t *testing.T, res time.Duration, opts ...snatch.Option) snatch.Store
+
+var storeFactories = map[string]storeFactory{
+	"memory": func(t *testing.T, res time.Duration, opts ...snatch.Option) snatch.Store {
+		return snatch.NewStore(res, opts...)
+	},
+	"wal": func(t *testing.T, res time.Duration, opts ...snatch.Option) snatch.Store {
+		s, err := snatch.NewWALStore(filepath.Join(t.TempDir(), "wal.log"), res, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	},
+}
+
+// forEachStore runs the test against each Store implementation.
+func forEachStore(t *testing.T, fn func(t *testing.T, newStore storeFactory)) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			fn(t, newStore)
+		})
+	}
+}
+
 func TestNewStore(t *testing.T) {
 	s := snatch.NewStore(10 * time.Second)
 
 	assert.Implements(t, (*snatch.Store)(nil), s)
 }
 
-func TestMemStore_Put(t *testing.T) {
-	bkts := []*snatch.Bucket{
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
-				Name: "foo",
-				Type: "count",
+func TestStore_Put(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		bkts := []*snatch.Bucket{
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
+					Name: "foo",
+					Type: "count",
+				},
+				Vals: []float64{1},
+				Sum:  1,
 			},
-			Vals: []float64{1},
-			Sum:  1,
-		},
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
-				Name: "bar",
-				Type: "measure",
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
+					Name: "bar",
+					Type: "measure",
+				},
+				Vals: []float64{2},
+				Sum:  2,
 			},
-			Vals: []float64{2},
-			Sum:  2,
-		},
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
-				Name: "bar",
-				Type: "measure",
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
+					Name: "bar",
+					Type: "measure",
+				},
+				Vals: []float64{3},
+				Sum:  3,
 			},
-			Vals: []float64{3},
-			Sum:  3,
-		},
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
-				Name: "bar",
-				Type: "measure",
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
+					Name: "bar",
+					Type: "measure",
+				},
+				Vals: []float64{3},
+				Sum:  3,
 			},
-			Vals: []float64{3},
-			Sum:  3,
-		},
-	}
-	s := snatch.NewStore(10 * time.Second)
+		}
+		s := newStore(t, 10*time.Second)
 
-	err := s.Add(bkts...)
+		err := s.Add(bkts...)
 
-	assert.NoError(t, err)
-	out, _ := s.Flush()
-	var sum float64
-	for bkt := range out {
-		sum += bkt.Sum
-	}
-	assert.Equal(t, float64(9), sum)
+		assert.NoError(t, err)
+		out, _ := s.Flush()
+		var sum float64
+		for bkt := range out {
+			sum += bkt.Sum
+		}
+		assert.Equal(t, float64(9), sum)
 
+	})
 }
 
-func TestMemStore_PutExpired(t *testing.T) {
-	bkts := []*snatch.Bucket{
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
-				Name: "foo",
-				Type: "count",
+func TestStore_PutExpired(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		bkts := []*snatch.Bucket{
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
+					Name: "foo",
+					Type: "count",
+				},
+				Vals: []float64{1},
+				Sum:  1,
 			},
-			Vals: []float64{1},
-			Sum:  1,
-		},
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
-				Name: "bar",
-				Type: "measure",
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
+					Name: "bar",
+					Type: "measure",
+				},
+				Vals: []float64{2},
+				Sum:  2,
 			},
-			Vals: []float64{2},
-			Sum:  2,
-		},
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
-				Name: "bar",
-				Type: "measure",
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
+					Name: "bar",
+					Type: "measure",
+				},
+				Vals: []float64{3},
+				Sum:  3,
 			},
-			Vals: []float64{3},
-			Sum:  3,
-		},
-		{
-			ID: &snatch.ID{
-				Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
-				Name: "bar",
-				Type: "measure",
+			{
+				ID: &snatch.ID{
+					Time: time.Now().Truncate(time.Second).Add(-1 * time.Second),
+					Name: "bar",
+					Type: "measure",
+				},
+				Vals: []float64{3},
+				Sum:  3,
 			},
-			Vals: []float64{3},
-			Sum:  3,
-		},
-	}
-	s := snatch.NewStore(10 * time.Second)
+		}
+		s := newStore(t, 10*time.Second)
 
-	err := s.Add(bkts...)
+		err := s.Add(bkts...)
 
-	assert.NoError(t, err)
+		assert.NoError(t, err)
 
+	})
 }
 
 func BenchmarkMemStore_Put(b *testing.B) {
@@ -145,109 +176,511 @@ func BenchmarkMemStore_Put(b *testing.B) {
 	}
 }
 
-func TestMemStore_Scan(t *testing.T) {
-	s := snatch.NewStore(10 * time.Second)
+func TestStore_Scan(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		now := time.Date(2018, 10, 10, 10, 10, 10, 0, time.UTC)
+		clock := snatch.NewFakeClock(now)
+		s := newStore(t, 10*time.Second, snatch.WithClock(clock))
 
-	bkt := &snatch.Bucket{
-		ID: &snatch.ID{
-			Time: time.Now().Truncate(10 * time.Second).Add(-12 * time.Second),
-			Name: "foo",
-			Type: "test",
-		},
-	}
-	bkt.Append(2.353)
-	err := s.Add(bkt)
-	assert.NoError(t, err)
-	bkt = &snatch.Bucket{
-		ID: &snatch.ID{
-			Time: time.Now(),
-			Name: "foo",
-			Type: "test",
-		},
-	}
-	bkt.Append(2.353)
-	err = s.Add(bkt)
-	assert.NoError(t, err)
+		bkt := &snatch.Bucket{
+			ID: &snatch.ID{
+				Time: now.Add(-10 * time.Second),
+				Name: "foo",
+				Type: "test",
+			},
+		}
+		bkt.Append(2.353)
+		err := s.Add(bkt)
+		assert.NoError(t, err)
+		bkt = &snatch.Bucket{
+			ID: &snatch.ID{
+				Time: now,
+				Name: "foo",
+				Type: "test",
+			},
+		}
+		bkt.Append(2.353)
+		err = s.Add(bkt)
+		assert.NoError(t, err)
 
-	time.Sleep(2 * time.Second)
+		out, err := s.Scan()
+		assert.NoError(t, err)
+		assert.Len(t, out, 0)
 
-	out, err := s.Scan()
+		clock.Add(time.Second)
+		out, err = s.Scan()
 
-	var b []*snatch.Bucket
-	for bkt := range out {
-		b = append(b, bkt)
-	}
-	assert.NoError(t, err)
-	assert.Len(t, b, 1)
-	assert.Equal(t, float64(2.353), b[0].Sum)
+		var b []*snatch.Bucket
+		for bkt := range out {
+			b = append(b, bkt)
+		}
+		assert.NoError(t, err)
+		assert.Len(t, b, 1)
+		assert.Equal(t, float64(2.353), b[0].Sum)
+	})
 }
 
-func TestMemStore_Flush(t *testing.T) {
-	s := snatch.NewStore(10 * time.Second)
+func TestStore_Flush(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		s := newStore(t, 10*time.Second)
 
-	bkt := &snatch.Bucket{
-		ID: &snatch.ID{
-			Time: time.Now(),
-			Name: "foo",
-			Type: "test",
-		},
-	}
-	bkt.Append(2.353)
-	err := s.Add(bkt)
-	assert.NoError(t, err)
+		bkt := &snatch.Bucket{
+			ID: &snatch.ID{
+				Time: time.Now(),
+				Name: "foo",
+				Type: "test",
+			},
+		}
+		bkt.Append(2.353)
+		err := s.Add(bkt)
+		assert.NoError(t, err)
 
-	out, err := s.Flush()
+		out, err := s.Flush()
 
-	var b []*snatch.Bucket
-	for bkt := range out {
-		b = append(b, bkt)
-	}
-	assert.NoError(t, err)
-	assert.Len(t, b, 1)
-	assert.Equal(t, float64(2.353), b[0].Sum)
+		var b []*snatch.Bucket
+		for bkt := range out {
+			b = append(b, bkt)
+		}
+		assert.NoError(t, err)
+		assert.Len(t, b, 1)
+		assert.Equal(t, float64(2.353), b[0].Sum)
+	})
 }
 
-func TestMemStore_CanConcurrentlyPutAndScan(t *testing.T) {
-	s := snatch.NewStore(10 * time.Second)
-	_ = s.Add(&snatch.Bucket{
-		ID: &snatch.ID{
-			Time: time.Now().Truncate(10 * time.Second).Add(-12 * time.Second),
-			Name: "foo",
-			Type: "count",
-		},
-		Vals: []float64{1},
-		Sum:  1,
+func TestStore_CanConcurrentlyPutAndScan(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		now := time.Now().Truncate(10 * time.Second)
+		clock := snatch.NewFakeClock(now.Add(time.Second))
+		s := newStore(t, 10*time.Second, snatch.WithClock(clock))
+		_ = s.Add(&snatch.Bucket{
+			ID: &snatch.ID{
+				Time: now.Add(-10 * time.Second),
+				Name: "foo",
+				Type: "count",
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		})
+
+		done := make(chan struct{}, 1)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+
+				default:
+					_ = s.Add(&snatch.Bucket{
+						ID: &snatch.ID{
+							Time: now,
+							Name: "foo",
+							Type: "count",
+						},
+						Vals: []float64{1},
+						Sum:  1,
+					})
+				}
+			}
+		}()
+
+		out, _ := s.Scan()
+		var b []*snatch.Bucket
+		for bkt := range out {
+			b = append(b, bkt)
+		}
+		assert.Len(t, b, 1)
+
+		done <- struct{}{}
 	})
+}
 
-	time.Sleep(2 * time.Second)
+func TestStore_ScanRollsUp(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		s := newStore(t, 10*time.Second, snatch.WithRollups(snatch.Rollup{Res: time.Minute}))
 
-	done := make(chan struct{}, 1)
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
+		start := time.Now().Truncate(time.Minute).Add(-3 * time.Minute)
+		for i := 0; i < 6; i++ {
+			bkt := &snatch.Bucket{
+				ID: &snatch.ID{
+					Time: start.Add(time.Duration(i) * 10 * time.Second),
+					Name: "foo",
+					Type: snatch.Measure,
+					Res:  10 * time.Second,
+				},
+			}
+			bkt.Append(float64(i))
+			_ = s.Add(bkt)
+		}
+
+		out, err := s.Scan()
 
-			default:
-				_ = s.Add(&snatch.Bucket{
+		assert.NoError(t, err)
+		var fine, coarse []*snatch.Bucket
+		for bkt := range out {
+			if bkt.ID.Res == time.Minute {
+				coarse = append(coarse, bkt)
+				continue
+			}
+			fine = append(fine, bkt)
+		}
+		assert.Len(t, fine, 6)
+		assert.False(t, fine[0].ID.Rollup)
+		if assert.Len(t, coarse, 1) {
+			assert.True(t, coarse[0].ID.Rollup)
+			assert.Equal(t, start, coarse[0].ID.Time)
+			assert.Equal(t, []float64{0, 1, 2, 3, 4, 5}, coarse[0].Vals)
+			assert.Equal(t, float64(15), coarse[0].Sum)
+		}
+	})
+}
+
+func TestStore_FlushRollsUp(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		s := newStore(t, time.Second, snatch.WithRollups(
+			snatch.Rollup{Res: time.Hour},
+			snatch.Rollup{Res: time.Minute},
+		))
+
+		bkt := &snatch.Bucket{
+			ID: &snatch.ID{
+				Time: time.Now().Truncate(time.Second),
+				Name: "foo",
+				Type: snatch.Count,
+				Res:  time.Second,
+			},
+		}
+		bkt.Append(2)
+		_ = s.Add(bkt)
+
+		out, err := s.Flush()
+
+		assert.NoError(t, err)
+		sums := map[time.Duration]float64{}
+		for bkt := range out {
+			sums[bkt.ID.Res] += bkt.Sum
+		}
+		assert.Equal(t, map[time.Duration]float64{time.Second: 2, time.Minute: 2, time.Hour: 2}, sums)
+	})
+}
+
+func TestStore_ScanUsesBucketResolution(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		s := newStore(t, time.Hour)
+
+		ts := time.Now().Truncate(time.Second).Add(-3 * time.Second)
+		_ = s.Add(&snatch.Bucket{
+			ID: &snatch.ID{
+				Time: ts,
+				Name: "fine",
+				Type: snatch.Count,
+				Res:  time.Second,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		}, &snatch.Bucket{
+			ID: &snatch.ID{
+				Time: ts,
+				Name: "coarse",
+				Type: snatch.Count,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		})
+
+		out, err := s.Scan()
+
+		assert.NoError(t, err)
+		var b []*snatch.Bucket
+		for bkt := range out {
+			b = append(b, bkt)
+		}
+		if assert.Len(t, b, 1) {
+			assert.Equal(t, "fine", b[0].ID.Name)
+		}
+	})
+}
+
+func TestStore_ScanWaitsForLateness(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		s := newStore(t, time.Second, snatch.WithLateness(time.Hour))
+
+		_ = s.Add(&snatch.Bucket{
+			ID: &snatch.ID{
+				Time: time.Now().Truncate(time.Second).Add(-1 * time.Minute),
+				Name: "foo",
+				Type: snatch.Count,
+			},
+			Vals: []float64{1},
+			Sum:  1,
+		})
+
+		out, err := s.Scan()
+
+		assert.NoError(t, err)
+		assert.Len(t, out, 0)
+	})
+}
+
+func TestStore_LatePolicies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		tests := []struct {
+			policy  snatch.LatePolicy
+			sums    []float64
+			late    bool
+			dropped int64
+		}{
+			{
+				policy:  snatch.LateDrop,
+				sums:    nil,
+				dropped: 1,
+			},
+			{
+				policy: snatch.LateMerge,
+				sums:   []float64{3},
+			},
+			{
+				policy: snatch.LateRoute,
+				sums:   []float64{2},
+				late:   true,
+			},
+		}
+
+		for _, tt := range tests {
+			s := newStore(t, time.Second, snatch.WithLatePolicy(tt.policy, time.Hour))
+			ts := time.Now().Truncate(time.Second).Add(-1 * time.Minute)
+			bkt := func(v float64) *snatch.Bucket {
+				b := &snatch.Bucket{
 					ID: &snatch.ID{
-						Time: time.Now(),
+						Time: ts,
 						Name: "foo",
-						Type: "count",
+						Type: snatch.Count,
 					},
-					Vals: []float64{1},
-					Sum:  1,
-				})
+				}
+				b.Append(v)
+				return b
+			}
+
+			_ = s.Add(bkt(1))
+			out, _ := s.Scan()
+			assert.Len(t, out, 1, tt.policy)
+
+			_ = s.Add(bkt(2))
+			out, err := s.Scan()
+
+			assert.NoError(t, err)
+			var sums []float64
+			for b := range out {
+				assert.Equal(t, tt.late, b.ID.Late, tt.policy)
+				assert.Equal(t, tt.policy == snatch.LateMerge, b.Merged, tt.policy)
+				sums = append(sums, b.Sum)
+			}
+			assert.Equal(t, tt.sums, sums, tt.policy)
+			assert.Equal(t, tt.dropped, s.(snatch.StatsReporter).Stats().Late, tt.policy)
+		}
+	})
+}
+
+func TestStore_LateMergeUpdatesRollups(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		s := newStore(t, time.Second,
+			snatch.WithRollups(snatch.Rollup{Res: time.Hour}),
+			snatch.WithLatePolicy(snatch.LateMerge, time.Hour),
+		)
+		ts := time.Now().Truncate(time.Second).Add(-1 * time.Minute)
+		_ = s.Add(&snatch.Bucket{
+			ID:   &snatch.ID{Time: ts, Name: "foo", Type: snatch.Count},
+			Vals: []float64{1},
+			Sum:  1,
+		})
+		_, _ = s.Scan()
+		_ = s.Add(&snatch.Bucket{
+			ID:   &snatch.ID{Time: ts, Name: "foo", Type: snatch.Count},
+			Vals: []float64{2},
+			Sum:  2,
+		})
+
+		out, err := s.Flush()
+
+		assert.NoError(t, err)
+		sums := map[time.Duration]float64{}
+		for b := range out {
+			sums[b.ID.Res] += b.Sum
+		}
+		assert.Equal(t, map[time.Duration]float64{0: 3, time.Hour: 3}, sums)
+	})
+}
+
+func TestStore_ScanGapFills(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+		clock := snatch.NewFakeClock(now)
+		s := newStore(t, 10*time.Second, snatch.WithClock(clock), snatch.WithGapFill(2))
+		_ = s.Add(&snatch.Bucket{
+			ID:   &snatch.ID{Time: now, Name: "errors", Type: snatch.Count},
+			Vals: []float64{3},
+			Sum:  3,
+		}, &snatch.Bucket{
+			ID:    &snatch.ID{Time: now, Name: "queue", Type: snatch.Sample},
+			Units: "items",
+			Vals:  []float64{5, 7},
+			Sum:   12,
+		}, &snatch.Bucket{
+			ID:   &snatch.ID{Time: now, Name: "latency", Type: snatch.Measure},
+			Vals: []float64{1},
+			Sum:  1,
+		})
+
+		scan := func() map[string]*snatch.Bucket {
+			clock.Add(10 * time.Second)
+			out, err := s.Scan()
+			assert.NoError(t, err)
+
+			bkts := map[string]*snatch.Bucket{}
+			for bkt := range out {
+				bkts[bkt.ID.Name] = bkt
+			}
+			return bkts
+		}
+
+		clock.Add(time.Second)
+		bkts := scan()
+		assert.Len(t, bkts, 3)
+
+		for i := 1; i <= 2; i++ {
+			bkts = scan()
+			if assert.Len(t, bkts, 2) {
+				assert.Equal(t, now.Add(time.Duration(i)*10*time.Second), bkts["errors"].ID.Time)
+				assert.Equal(t, []float64{0}, bkts["errors"].Vals)
+				assert.Equal(t, []float64{7}, bkts["queue"].Vals)
+				assert.Equal(t, "items", bkts["queue"].Units)
+			}
+		}
+
+		bkts = scan()
+		assert.Len(t, bkts, 0)
+	})
+}
+
+func TestStore_ScanGapFillsBetweenData(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+		clock := snatch.NewFakeClock(now.Add(31 * time.Second))
+		s := newStore(t, 10*time.Second, snatch.WithClock(clock), snatch.WithGapFill(5))
+		for _, ts := range []time.Time{now, now.Add(20 * time.Second)} {
+			_ = s.Add(&snatch.Bucket{
+				ID:   &snatch.ID{Time: ts, Name: "errors", Type: snatch.Count},
+				Vals: []float64{1},
+				Sum:  1,
+			})
+		}
+
+		out, err := s.Scan()
+
+		assert.NoError(t, err)
+		sums := map[time.Time]float64{}
+		for bkt := range out {
+			sums[bkt.ID.Time] += bkt.Sum
+		}
+		assert.Equal(t, map[time.Time]float64{
+			now:                       1,
+			now.Add(10 * time.Second): 0,
+			now.Add(20 * time.Second): 1,
+		}, sums)
+	})
+}
+
+func TestStore_AddResolvesRelativeSamples(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+		clock := snatch.NewFakeClock(now)
+		s := newStore(t, 10*time.Second, snatch.WithClock(clock))
+		sample := func(ts time.Time, v float64, rel bool) *snatch.Bucket {
+			b := &snatch.Bucket{
+				ID:       &snatch.ID{Time: ts, Name: "queue", Type: snatch.Sample},
+				Relative: rel,
 			}
+			b.Append(v)
+			return b
+		}
+
+		_ = s.Add(sample(now, 10, false), sample(now, 5, true), sample(now, -3, true))
+		clock.Add(11 * time.Second)
+		out, _ := s.Scan()
+		if assert.Len(t, out, 1) {
+			assert.Equal(t, []float64{10, 15, 12}, (<-out).Vals)
+		}
+
+		_ = s.Add(sample(now.Add(10*time.Second), 2, true))
+		out, err := s.Flush()
+
+		assert.NoError(t, err)
+		if assert.Len(t, out, 1) {
+			b := <-out
+			assert.Equal(t, []float64{14}, b.Vals)
+			assert.False(t, b.Relative)
+		}
+	})
+}
+
+func TestStore_SnapshotRestore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, newStore storeFactory) {
+		now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+		s := newStore(t, 10*time.Second)
+		_ = s.Add(&snatch.Bucket{
+			ID:   &snatch.ID{Time: now, Name: "foo", Tags: []string{"a", "b"}, Type: snatch.Measure},
+			Vals: []float64{1, 2},
+			Sum:  3,
+		})
+		buf := &bytes.Buffer{}
+
+		err := s.(snatch.Snapshotter).Snapshot(buf)
+
+		assert.NoError(t, err)
+
+		s = newStore(t, 10*time.Second)
+		_ = s.Add(&snatch.Bucket{
+			ID:   &snatch.ID{Time: now, Name: "foo", Tags: []string{"a", "b"}, Type: snatch.Measure},
+			Vals: []float64{3},
+			Sum:  3,
+		})
+
+		err = s.(snatch.Snapshotter).Restore(buf)
+
+		assert.NoError(t, err)
+		out, _ := s.Flush()
+		if assert.Len(t, out, 1) {
+			bkt := <-out
+			assert.Equal(t, []float64{3, 1, 2}, bkt.Vals)
+			assert.Equal(t, float64(6), bkt.Sum)
+		}
+	})
+}
+
+// lateMergeScans returns the Buckets of two scans of a Store merging
+// late data, the second being the first Bucket with late data merged.
+func lateMergeScans(t *testing.T) ([]*snatch.Bucket, []*snatch.Bucket) {
+	t.Helper()
+
+	s := snatch.NewStore(time.Second, snatch.WithLatePolicy(snatch.LateMerge, time.Hour))
+	ts := time.Now().Truncate(time.Second).Add(-1 * time.Minute)
+	scan := func(v float64) []*snatch.Bucket {
+		bkt := &snatch.Bucket{
+			ID: &snatch.ID{Time: ts, Name: "test", Tags: []string{"source", "web"}, Type: snatch.Count, Res: time.Second},
+		}
+		bkt.Append(v)
+		_ = s.Add(bkt)
+
+		out, err := s.Scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bkts []*snatch.Bucket
+		for b := range out {
+			bkts = append(bkts, b)
 		}
-	}()
 
-	out, _ := s.Scan()
-	var b []*snatch.Bucket
-	for bkt := range out {
-		b = append(b, bkt)
+		return bkts
 	}
-	assert.Len(t, b, 1)
 
-	done <- struct{}{}
+	return scan(1), scan(2)
 }
@@ -0,0 +1,279 @@
+package snatch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record states, set on records of Store state rather than Buckets.
+const (
+	// recordGauge is the current value of the Sample series Key.
+	recordGauge = "gauge"
+	// recordClosed is an emitted Bucket late data is merged into.
+	recordClosed = "closed"
+	// recordDirty is a closed Bucket with merged late data that
+	// has not been emitted.
+	recordDirty = "dirty"
+)
+
+// record is the persisted form of a Bucket, or of Store state
+// if State is set.
+type record struct {
+	Time     int64     `json:"t"`
+	Name     string    `json:"n"`
+	Tags     []string  `json:"g,omitempty"`
+	Type     Type      `json:"y"`
+	Res      int64     `json:"r,omitempty"`
+	Late     bool      `json:"l,omitempty"`
+	Rollup   bool      `json:"ro,omitempty"`
+	Units    string    `json:"u,omitempty"`
+	Vals     []float64 `json:"v"`
+	Relative bool      `json:"rel,omitempty"`
+	State    string    `json:"s,omitempty"`
+	Key      string    `json:"k,omitempty"`
+}
+
+func newRecord(bkt *Bucket) record {
+	return record{
+		Time:     bkt.ID.Time.UnixNano(),
+		Name:     bkt.ID.Name,
+		Tags:     bkt.ID.Tags,
+		Type:     bkt.ID.Type,
+		Res:      int64(bkt.ID.Res),
+		Late:     bkt.ID.Late,
+		Rollup:   bkt.ID.Rollup,
+		Units:    bkt.Units,
+		Vals:     bkt.Vals,
+		Relative: bkt.Relative,
+	}
+}
+
+// Bucket returns the Bucket of the record.
+func (r record) Bucket() *Bucket {
+	bkt := &Bucket{
+		ID: &ID{
+			Time:   time.Unix(0, r.Time),
+			Name:   r.Name,
+			Tags:   r.Tags,
+			Type:   r.Type,
+			Res:    time.Duration(r.Res),
+			Late:   r.Late,
+			Rollup: r.Rollup,
+		},
+		Units:    r.Units,
+		Relative: r.Relative,
+	}
+	for _, v := range r.Vals {
+		bkt.Append(v)
+	}
+
+	return bkt
+}
+
+// writeRecords writes the Buckets as records, one per line.
+func writeRecords(w io.Writer, bkts []*Bucket) error {
+	recs := make([]record, 0, len(bkts))
+	for _, bkt := range bkts {
+		recs = append(recs, newRecord(bkt))
+	}
+
+	return encodeRecords(w, recs)
+}
+
+// encodeRecords writes the records, one per line.
+func encodeRecords(w io.Writer, recs []record) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readRecords reads records, calling fn with each Bucket.
+//
+// A truncated last record, left by a crash while writing, is ignored.
+func readRecords(r io.Reader, fn func(*Bucket)) error {
+	_, err := decodeRecords(r, func(rec record) {
+		fn(rec.Bucket())
+	})
+
+	return err
+}
+
+// decodeRecords reads records, calling fn with each record. It returns
+// the offset of the end of the last complete record if the last record
+// is truncated, or -1 if it is not.
+func decodeRecords(r io.Reader, fn func(record)) (int64, error) {
+	dec := json.NewDecoder(r)
+	for {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			switch err {
+			case io.EOF:
+				return -1, nil
+			case io.ErrUnexpectedEOF:
+				return dec.InputOffset(), nil
+			}
+
+			return -1, err
+		}
+
+		fn(rec)
+	}
+}
+
+type walStore struct {
+	*memStore
+
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// NewWALStore creates a new in-memory store backed by a write-ahead log.
+//
+// The Buckets in the log are replayed into the store on creation, and the
+// log is compacted to the Buckets that have not been emitted on each Commit.
+// The current values of Sample series and, with LateMerge, the emitted
+// Buckets late data is merged into are kept in the log.
+func NewWALStore(path string, res time.Duration, opts ...Option) (Store, error) {
+	s := &walStore{
+		memStore: newMemStore(res, opts...),
+		path:     path,
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+
+	return s, nil
+}
+
+func (s *walStore) replay() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	end, err := decodeRecords(bufio.NewReader(f), func(rec record) {
+		if !s.memStore.restoreState(rec) {
+			_ = s.memStore.Add(rec.Bucket())
+		}
+	})
+	if err != nil || end < 0 {
+		return err
+	}
+
+	// Cut off the truncated record, so the next record is not
+	// appended to it.
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	if end > 0 {
+		_, err = f.WriteAt([]byte("\n"), end)
+	}
+	return err
+}
+
+// Restore adds the Buckets from a snapshot into the Store, logging them.
+func (s *walStore) Restore(r io.Reader) error {
+	return s.memStore.restore(r, s.Add)
+}
+
+// Add adds Buckets into the Store, logging them first.
+func (s *walStore) Add(bkts ...*Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeRecords(s.w, bkts); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	return s.memStore.Add(bkts...)
+}
+
+// Commit compacts the log to the Buckets that have not been emitted yet.
+func (s *walStore) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bkts, err := s.memStore.pending()
+	if err != nil {
+		return err
+	}
+	state := s.memStore.state()
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := encodeRecords(w, state); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeRecords(w, bkts); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	_ = s.f.Close()
+	s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.w.Reset(s.f)
+
+	return nil
+}
+
+// Close closes the log.
+func (s *walStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	return s.f.Close()
+}
@@ -0,0 +1,255 @@
+package snatch_test
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWALStore(t *testing.T) {
+	s, err := snatch.NewWALStore(filepath.Join(t.TempDir(), "wal.log"), 10*time.Second)
+
+	assert.NoError(t, err)
+	assert.Implements(t, (*snatch.Store)(nil), s)
+	assert.Implements(t, (*snatch.Committer)(nil), s)
+	assert.NoError(t, s.(io.Closer).Close())
+}
+
+func TestNewWALStoreErrorsOnBadPath(t *testing.T) {
+	_, err := snatch.NewWALStore(filepath.Join(t.TempDir(), "foo", "wal.log"), 10*time.Second)
+
+	assert.Error(t, err)
+}
+
+func TestWALStore_Replays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	s, _ := snatch.NewWALStore(path, 10*time.Second)
+	_ = s.Add(&snatch.Bucket{
+		ID: &snatch.ID{
+			Time: time.Unix(414631410, 0),
+			Name: "foo",
+			Tags: []string{"foo", "bar"},
+			Type: snatch.Measure,
+			Res:  10 * time.Second,
+		},
+		Units: "ms",
+		Vals:  []float64{1, 2},
+		Sum:   3,
+	})
+	_ = s.(io.Closer).Close()
+
+	s, err := snatch.NewWALStore(path, 10*time.Second)
+
+	assert.NoError(t, err)
+	out, _ := s.Flush()
+	if assert.Len(t, out, 1) {
+		bkt := <-out
+		assert.Equal(t, &snatch.ID{
+			Time: time.Unix(414631410, 0),
+			Name: "foo",
+			Tags: []string{"foo", "bar"},
+			Type: snatch.Measure,
+			Res:  10 * time.Second,
+		}, bkt.ID)
+		assert.Equal(t, "ms", bkt.Units)
+		assert.Equal(t, []float64{1, 2}, bkt.Vals)
+		assert.Equal(t, float64(3), bkt.Sum)
+	}
+}
+
+func TestWALStore_ReplayIgnoresTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	data := `{"t":414631410000000000,"n":"foo","y":"count","v":[1]}
+{"t":414631410000000000,"n":"foo","y":"cou`
+	_ = ioutil.WriteFile(path, []byte(data), 0644)
+
+	s, err := snatch.NewWALStore(path, 10*time.Second)
+
+	assert.NoError(t, err)
+	out, _ := s.Flush()
+	assert.Len(t, out, 1)
+}
+
+func TestWALStore_ReplayCutsOffTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	data := `{"t":414631410000000000,"n":"foo","y":"count","v":[1]}
+{"t":414631410000000000,"n":"foo","y":"cou`
+	_ = ioutil.WriteFile(path, []byte(data), 0644)
+	s, _ := snatch.NewWALStore(path, 10*time.Second)
+	_ = s.Add(&snatch.Bucket{
+		ID:   &snatch.ID{Time: time.Unix(414631410, 0), Name: "bar", Type: snatch.Count},
+		Vals: []float64{1},
+		Sum:  1,
+	})
+	_ = s.(io.Closer).Close()
+
+	s, err := snatch.NewWALStore(path, 10*time.Second)
+
+	assert.NoError(t, err)
+	out, _ := s.Flush()
+	assert.Len(t, out, 2)
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, `{"t":414631410000000000,"n":"foo","y":"count","v":[1]}
+{"t":414631410000000000,"n":"bar","y":"count","v":[1]}
+`, string(b))
+}
+
+func TestWALStore_ReplayErrorsOnCorruptLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	_ = ioutil.WriteFile(path, []byte("foo\n"), 0644)
+
+	_, err := snatch.NewWALStore(path, 10*time.Second)
+
+	assert.Error(t, err)
+}
+
+func TestWALStore_CommitCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now.Add(11 * time.Second))
+	s, _ := snatch.NewWALStore(path, 10*time.Second, snatch.WithClock(clock))
+	_ = s.Add(&snatch.Bucket{
+		ID:   &snatch.ID{Time: now, Name: "done", Type: snatch.Count},
+		Vals: []float64{1},
+		Sum:  1,
+	}, &snatch.Bucket{
+		ID:   &snatch.ID{Time: now.Add(10 * time.Second), Name: "pending", Type: snatch.Count},
+		Vals: []float64{1},
+		Sum:  1,
+	})
+	out, _ := s.Scan()
+	assert.Len(t, out, 1)
+
+	err := s.(snatch.Committer).Commit()
+
+	assert.NoError(t, err)
+	_ = s.Add(&snatch.Bucket{
+		ID:   &snatch.ID{Time: now.Add(10 * time.Second), Name: "pending", Type: snatch.Count},
+		Vals: []float64{2},
+		Sum:  2,
+	})
+	_ = s.(io.Closer).Close()
+
+	s, _ = snatch.NewWALStore(path, 10*time.Second)
+	out, _ = s.Flush()
+	if assert.Len(t, out, 1) {
+		bkt := <-out
+		assert.Equal(t, "pending", bkt.ID.Name)
+		assert.Equal(t, float64(3), bkt.Sum)
+	}
+	_ = s.(io.Closer).Close()
+}
+
+func TestWALStore_CommitKeepsEvictedBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy snatch.BudgetPolicy
+	}{
+		{name: "flush", policy: snatch.BudgetFlush},
+		{name: "spill", policy: snatch.BudgetSpill},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "wal.log")
+			now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+			s, _ := snatch.NewWALStore(path, 10*time.Second, snatch.WithBudget(snatch.Budget{
+				MaxSeries: 1,
+				Policy:    tt.policy,
+				SpillPath: filepath.Join(dir, "spill"),
+			}))
+			_ = s.Add(&snatch.Bucket{
+				ID:   &snatch.ID{Time: now, Name: "evicted", Type: snatch.Count},
+				Vals: []float64{1},
+				Sum:  1,
+			}, &snatch.Bucket{
+				ID:   &snatch.ID{Time: now.Add(10 * time.Second), Name: "held", Type: snatch.Count},
+				Vals: []float64{1},
+				Sum:  1,
+			})
+
+			err := s.(snatch.Committer).Commit()
+
+			assert.NoError(t, err)
+			_ = s.(io.Closer).Close()
+
+			s, _ = snatch.NewWALStore(path, 10*time.Second)
+			out, _ := s.Flush()
+			assert.Len(t, out, 2)
+		})
+	}
+}
+
+func TestWALStore_CommitKeepsGaugeValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now.Add(11 * time.Second))
+	s, _ := snatch.NewWALStore(path, 10*time.Second, snatch.WithClock(clock))
+	_ = s.Add(&snatch.Bucket{
+		ID:   &snatch.ID{Time: now, Name: "mem", Type: snatch.Sample},
+		Vals: []float64{5},
+		Sum:  5,
+	})
+	out, _ := s.Scan()
+	assert.Len(t, out, 1)
+	err := s.(snatch.Committer).Commit()
+	assert.NoError(t, err)
+	_ = s.(io.Closer).Close()
+
+	s, _ = snatch.NewWALStore(path, 10*time.Second, snatch.WithClock(clock))
+	_ = s.Add(&snatch.Bucket{
+		ID:       &snatch.ID{Time: now.Add(10 * time.Second), Name: "mem", Type: snatch.Sample},
+		Vals:     []float64{1},
+		Sum:      1,
+		Relative: true,
+	})
+
+	out, _ = s.Flush()
+	if assert.Len(t, out, 1) {
+		assert.Equal(t, []float64{6}, (<-out).Vals)
+	}
+	_ = s.(io.Closer).Close()
+}
+
+func TestWALStore_CommitKeepsMergeState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	now := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+	clock := snatch.NewFakeClock(now.Add(11 * time.Second))
+	opts := []snatch.Option{snatch.WithClock(clock), snatch.WithLatePolicy(snatch.LateMerge, time.Hour)}
+	s, _ := snatch.NewWALStore(path, 10*time.Second, opts...)
+	bkt := func(v float64) *snatch.Bucket {
+		return &snatch.Bucket{
+			ID:   &snatch.ID{Time: now, Name: "req", Type: snatch.Count},
+			Vals: []float64{v},
+			Sum:  v,
+		}
+	}
+	_ = s.Add(bkt(1))
+	out, _ := s.Scan()
+	assert.Len(t, out, 1)
+	_ = s.Add(bkt(2))
+	err := s.(snatch.Committer).Commit()
+	assert.NoError(t, err)
+	_ = s.(io.Closer).Close()
+
+	s, _ = snatch.NewWALStore(path, 10*time.Second, opts...)
+	out, _ = s.Scan()
+
+	if assert.Len(t, out, 1) {
+		merged := <-out
+		assert.True(t, merged.Merged)
+		assert.Equal(t, float64(3), merged.Sum)
+	}
+	_ = s.Add(bkt(3))
+	out, _ = s.Scan()
+	if assert.Len(t, out, 1) {
+		assert.Equal(t, float64(6), (<-out).Sum)
+	}
+	_ = s.(io.Closer).Close()
+}
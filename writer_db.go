@@ -0,0 +1,255 @@
+package snatch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// Format represents the output format of a WriterDB.
+type Format string
+
+// Format constants.
+const (
+	// FormatLine writes InfluxDB line protocol.
+	FormatLine Format = "line"
+	// FormatJSON writes a JSON object per line.
+	FormatJSON Format = "json"
+	// FormatLogfmt writes l2met style logfmt.
+	FormatLogfmt Format = "logfmt"
+	// FormatCSV writes a CSV row per field.
+	FormatCSV Format = "csv"
+)
+
+// ParseFormat parses an output format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatLine, FormatJSON, FormatLogfmt, FormatCSV:
+		return f, nil
+	}
+
+	return "", errors.New("snatch: invalid format: " + s)
+}
+
+type writerDB struct {
+	mu      sync.Mutex
+	w       io.Writer
+	header  bool
+	format  Format
+	rollups map[time.Duration]Rollup
+	agg     SampleAgg
+}
+
+// NewWriterDB creates a DB that writes Buckets to the Writer in the
+// given format, ordered by time, name and tags.
+//
+// Closing the DB does not close the Writer.
+func NewWriterDB(w io.Writer, format Format, opts ...Option) DB {
+	o := newOptions(opts)
+
+	return &writerDB{
+		w:       w,
+		format:  format,
+		rollups: rollupsByRes(o.rollups),
+		agg:     o.sampleAgg,
+	}
+}
+
+// Insert writes the Buckets to the Writer.
+func (db *writerDB) Insert(bkts []*Bucket) error {
+	var buf bytes.Buffer
+	if err := encodeBuckets(&buf, sortBuckets(bkts), db.format, db.rollups, db.agg); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.format == FormatCSV && !db.header {
+		if _, err := io.WriteString(db.w, csvHeader); err != nil {
+			return err
+		}
+		db.header = true
+	}
+
+	_, err := db.w.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the database.
+func (db *writerDB) Close() error {
+	return nil
+}
+
+// encodeBuckets writes the Buckets to the buffer in the given format.
+func encodeBuckets(buf *bytes.Buffer, bkts []*Bucket, format Format, rollups map[time.Duration]Rollup, agg SampleAgg) error {
+	for _, bkt := range bkts {
+		name := pointName(bkt, rollupOf(rollups, bkt))
+		fields := bkt.Fields(agg)
+
+		switch format {
+		case FormatJSON:
+			writeJSON(buf, name, bkt, fields)
+
+		case FormatLogfmt:
+			writeLogfmt(buf, name, bkt, fields)
+
+		case FormatCSV:
+			if err := writeCSV(buf, name, bkt, fields); err != nil {
+				return err
+			}
+
+		default:
+			p, err := client.NewPoint(name, tagMap(bkt.ID.Tags), fields, bkt.ID.Time)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(p.PrecisionString("s"))
+			buf.WriteByte('\n')
+		}
+	}
+
+	return nil
+}
+
+// sortBuckets returns a copy of the Buckets in a deterministic order.
+func sortBuckets(bkts []*Bucket) []*Bucket {
+	sorted := make([]*Bucket, len(bkts))
+	copy(sorted, bkts)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].ID, sorted[j].ID
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		_, ka := a.Keys()
+		_, kb := b.Keys()
+		return ka < kb
+	})
+
+	return sorted
+}
+
+// jsonPoint is the JSON representation of a Bucket.
+type jsonPoint struct {
+	Name   string                 `json:"name"`
+	Type   Type                   `json:"type"`
+	Tags   map[string]string      `json:"tags,omitempty"`
+	Time   time.Time              `json:"time"`
+	Units  string                 `json:"units,omitempty"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+func writeJSON(buf *bytes.Buffer, name string, bkt *Bucket, fields map[string]interface{}) {
+	p := jsonPoint{
+		Name:   name,
+		Type:   bkt.ID.Type,
+		Time:   bkt.ID.Time.UTC(),
+		Units:  bkt.Units,
+		Fields: fields,
+	}
+	if len(bkt.ID.Tags) > 0 {
+		p.Tags = tagMap(bkt.ID.Tags)
+	}
+
+	b, _ := json.Marshal(p)
+	buf.Write(b)
+	buf.WriteByte('\n')
+}
+
+func writeLogfmt(buf *bytes.Buffer, name string, bkt *Bucket, fields map[string]interface{}) {
+	buf.WriteString("t=")
+	buf.WriteString(bkt.ID.Time.UTC().Format(time.RFC3339))
+
+	for _, k := range sortedKeys(fields) {
+		buf.WriteByte(' ')
+		buf.WriteString(string(bkt.ID.Type))
+		buf.WriteByte('#')
+		buf.WriteString(name)
+		if k != "value" {
+			buf.WriteByte('.')
+			buf.WriteString(k)
+		}
+		buf.WriteByte('=')
+		buf.WriteString(formatField(fields[k]))
+		if k != "count" {
+			buf.WriteString(bkt.Units)
+		}
+	}
+
+	for i := 0; i+1 < len(bkt.ID.Tags); i += 2 {
+		buf.WriteByte(' ')
+		buf.WriteString(bkt.ID.Tags[i])
+		buf.WriteByte('=')
+		buf.WriteString(quoteLogfmt(bkt.ID.Tags[i+1]))
+	}
+	buf.WriteByte('\n')
+}
+
+// csvHeader is the header of the CSV format.
+const csvHeader = "time,name,type,tags,units,field,value\n"
+
+func writeCSV(buf *bytes.Buffer, name string, bkt *Bucket, fields map[string]interface{}) error {
+	tags := make([]string, 0, len(bkt.ID.Tags)/2)
+	for i := 0; i+1 < len(bkt.ID.Tags); i += 2 {
+		tags = append(tags, bkt.ID.Tags[i]+"="+bkt.ID.Tags[i+1])
+	}
+
+	w := csv.NewWriter(buf)
+	for _, k := range sortedKeys(fields) {
+		err := w.Write([]string{
+			bkt.ID.Time.UTC().Format(time.RFC3339),
+			name,
+			string(bkt.ID.Type),
+			strings.Join(tags, ","),
+			bkt.Units,
+			k,
+			formatField(fields[k]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// sortedKeys returns the keys of the fields in order.
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// formatField formats a field value.
+func formatField(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// quoteLogfmt quotes a logfmt value if required.
+func quoteLogfmt(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
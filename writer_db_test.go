@@ -0,0 +1,117 @@
+package snatch_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/snatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func writerBuckets() []*snatch.Bucket {
+	ts := time.Date(2018, 10, 10, 10, 10, 0, 0, time.UTC)
+
+	return []*snatch.Bucket{
+		{
+			ID:    &snatch.ID{Time: ts.Add(10 * time.Second), Name: "foo.measure", Tags: []string{"tag", "a b"}, Type: snatch.Measure},
+			Units: "ms",
+			Vals:  []float64{1, 2, 3, 4},
+			Sum:   10,
+		},
+		{
+			ID:   &snatch.ID{Time: ts, Name: "foo.sample", Tags: []string{"tag", "example"}, Type: snatch.Sample},
+			Vals: []float64{1, 2},
+			Sum:  3,
+		},
+		{
+			ID:   &snatch.ID{Time: ts, Name: "foo.counter", Tags: []string{"tag", "example"}, Type: snatch.Count},
+			Vals: []float64{1, 2, 3, 4},
+			Sum:  10,
+		},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, s := range []string{"line", "json", "logfmt", "csv"} {
+		f, err := snatch.ParseFormat(s)
+
+		assert.NoError(t, err)
+		assert.Equal(t, snatch.Format(s), f)
+	}
+
+	_, err := snatch.ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestWriterDB_InsertLine(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewWriterDB(&buf, snatch.FormatLine)
+
+	err := db.Insert(writerBuckets())
+
+	assert.NoError(t, err)
+	assert.Equal(t, `foo_counter,tag=example value=10i 1539166200
+foo_sample,tag=example value=2 1539166200
+foo_measure,tag=a\ b 90_percentile=4,95_percentile=4,97_percentile=4,99_percentile=4,count=4i,lower=1,mean=2.5,sum=10,upper=4 1539166210
+`, buf.String())
+}
+
+func TestWriterDB_InsertJSON(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewWriterDB(&buf, snatch.FormatJSON)
+
+	err := db.Insert(writerBuckets())
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"foo_counter","type":"count","tags":{"tag":"example"},"time":"2018-10-10T10:10:00Z","fields":{"value":10}}
+{"name":"foo_sample","type":"sample","tags":{"tag":"example"},"time":"2018-10-10T10:10:00Z","fields":{"value":2}}
+{"name":"foo_measure","type":"measure","tags":{"tag":"a b"},"time":"2018-10-10T10:10:10Z","units":"ms","fields":{"90_percentile":4,"95_percentile":4,"97_percentile":4,"99_percentile":4,"count":4,"lower":1,"mean":2.5,"sum":10,"upper":4}}
+`, buf.String())
+}
+
+func TestWriterDB_InsertLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewWriterDB(&buf, snatch.FormatLogfmt)
+
+	err := db.Insert(writerBuckets())
+
+	assert.NoError(t, err)
+	assert.Equal(t, `t=2018-10-10T10:10:00Z count#foo_counter=10 tag=example
+t=2018-10-10T10:10:00Z sample#foo_sample=2 tag=example
+t=2018-10-10T10:10:10Z measure#foo_measure.90_percentile=4ms measure#foo_measure.95_percentile=4ms measure#foo_measure.97_percentile=4ms measure#foo_measure.99_percentile=4ms measure#foo_measure.count=4 measure#foo_measure.lower=1ms measure#foo_measure.mean=2.5ms measure#foo_measure.sum=10ms measure#foo_measure.upper=4ms tag="a b"
+`, buf.String())
+}
+
+func TestWriterDB_InsertCSV(t *testing.T) {
+	var buf bytes.Buffer
+	db := snatch.NewWriterDB(&buf, snatch.FormatCSV)
+	bkts := writerBuckets()[1:]
+
+	err := db.Insert(bkts)
+	assert.NoError(t, err)
+	err = db.Insert(bkts[:1])
+	assert.NoError(t, err)
+
+	assert.Equal(t, `time,name,type,tags,units,field,value
+2018-10-10T10:10:00Z,foo_counter,count,tag=example,,value,10
+2018-10-10T10:10:00Z,foo_sample,sample,tag=example,,value,2
+2018-10-10T10:10:00Z,foo_sample,sample,tag=example,,value,2
+`, buf.String())
+}
+
+func TestWriterDB_InsertUsesRollupNames(t *testing.T) {
+	var buf bytes.Buffer
+	r, _ := snatch.ParseRollup("1m")
+	db := snatch.NewWriterDB(&buf, snatch.FormatLine, snatch.WithRollups(r))
+	bkt := &snatch.Bucket{
+		ID:   &snatch.ID{Time: time.Unix(60, 0), Name: "test", Type: snatch.Count, Res: time.Minute, Rollup: true, Late: true},
+		Vals: []float64{1},
+		Sum:  1,
+	}
+
+	err := db.Insert([]*snatch.Bucket{bkt})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test_1m0s_late value=1i 60\n", buf.String())
+}